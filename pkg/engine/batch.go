@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errTrySolo signals a DB.Batch call that it failed within the batch and has to be run in its own transaction.
+var errTrySolo = errors.New("batch function returned an error and should be re-run solo")
+
+// batch collects the calls of DB.Batch that are run in a single write transaction.
+type batch struct {
+	db    *DB
+	timer *time.Timer
+	calls []batchCall
+	start sync.Once
+}
+
+// batchCall is a function of DB.Batch together with the channel its result is reported to.
+type batchCall struct {
+	fn  func(tx *Transaction) error
+	err chan<- error
+}
+
+// Batch runs given function within a write transaction like DB.Update, but the functions of concurrent calls are
+// combined into a single transaction, which is committed and synced once. The batch is run once it holds
+// Options.MaxBatchSize functions or Options.MaxBatchDelay has passed. If a function fails, the transaction is run again
+// without it and the failed function is run in its own transaction, so its error is returned to its caller only.
+// Since a function might be called more than once, it must be idempotent apart from the changes of the transaction.
+func (db *DB) Batch(fn func(tx *Transaction) error) error {
+	errCh := make(chan error, 1)
+
+	db.batchLock.Lock()
+
+	if db.batch == nil || len(db.batch.calls) >= db.maxBatchSize {
+		db.batch = &batch{db: db}
+		db.batch.timer = time.AfterFunc(db.maxBatchDelay, db.batch.trigger)
+	}
+
+	db.batch.calls = append(db.batch.calls, batchCall{fn: fn, err: errCh})
+
+	if len(db.batch.calls) >= db.maxBatchSize {
+		go db.batch.trigger()
+	}
+
+	db.batchLock.Unlock()
+
+	err := <-errCh
+	if errors.Is(err, errTrySolo) {
+		err = db.Update(fn)
+	}
+
+	return err
+}
+
+// trigger runs the batch if it is not run already.
+func (b *batch) trigger() {
+	b.start.Do(b.run)
+}
+
+// run runs the calls of the batch in a single transaction and reports the result to every call. Failed calls are
+// removed from the batch and told to run on their own, then the transaction is repeated with the remaining calls.
+func (b *batch) run() {
+	b.db.batchLock.Lock()
+	b.timer.Stop()
+
+	// new calls go to a new batch from now on
+	if b.db.batch == b {
+		b.db.batch = nil
+	}
+
+	b.db.batchLock.Unlock()
+
+	for len(b.calls) > 0 {
+		failedIndex := -1
+
+		err := b.db.Update(func(tx *Transaction) error {
+			for index, call := range b.calls {
+				if err := callSafely(call.fn, tx); err != nil {
+					failedIndex = index
+
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if failedIndex == -1 {
+			for _, call := range b.calls {
+				call.err <- err
+			}
+
+			return
+		}
+
+		failedCall := b.calls[failedIndex]
+		b.calls[failedIndex], b.calls = b.calls[len(b.calls)-1], b.calls[:len(b.calls)-1]
+		failedCall.err <- errTrySolo
+	}
+}
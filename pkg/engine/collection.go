@@ -8,10 +8,19 @@ import (
 )
 
 const (
-	collectionSize = 16
+	collectionSize = 24
+	// legacyCollectionSize defines the size of collections serialized before the sequence was added, their counter
+	// was not maintained.
+	legacyCollectionSize = 16
 )
 
-var ErrWriteInsideReadTx = errors.New("can't perform a write operation inside a read transaction")
+var (
+	ErrWriteInsideReadTx   = errors.New("can't perform a write operation inside a read transaction")
+	ErrKeyTooLarge         = errors.New("key is too large")
+	ErrValueTooLarge       = errors.New("value is too large")
+	ErrIncompatibleValue   = errors.New("key holds a sub-collection instead of a value or vice versa")
+	errMalformedCollection = fmt.Errorf("%w: collection is malformed", ErrCorrupt)
+)
 
 // newCollection creates a new collection with given parameters.
 func newCollection(name []byte, root uint64) *Collection {
@@ -23,11 +32,22 @@ func newCollection(name []byte, root uint64) *Collection {
 
 // Collection represents a named Collection of key-value pairs.
 type Collection struct {
-	dal     *dal
-	tx      *Transaction
-	name    []byte
-	root    uint64
-	counter uint64
+	dal  *dal
+	tx   *Transaction
+	name []byte
+	root uint64
+	// counter holds the number of keys that hold a value.
+	counter  uint64
+	sequence uint64
+	// uncounted is set if the counter was not maintained when the collection was stored, it is counted when needed.
+	uncounted bool
+	// parent holds the collection that stores the collection, which is the root collection for the collections of
+	// the transaction.
+	parent *Collection
+	// path identifies the collection within the transaction, see collectionPath.
+	path string
+	// dirty is set if the tree of the collection was changed, which moves its root on commit.
+	dirty bool
 }
 
 func (c *Collection) serialize() *Item {
@@ -39,20 +59,76 @@ func (c *Collection) serialize() *Item {
 	leftPos += pageNumberSize
 	binary.LittleEndian.PutUint64(bytes[leftPos:], c.counter)
 
+	leftPos += pageNumberSize
+	binary.LittleEndian.PutUint64(bytes[leftPos:], c.sequence)
+
 	return NewItem(c.name, bytes)
 }
 
-func (c *Collection) deserialize(item *Item) {
+func (c *Collection) deserialize(item *Item) error {
 	c.name = item.key
 
 	if len(item.value) != 0 {
+		if len(item.value) < legacyCollectionSize {
+			return errMalformedCollection
+		}
+
 		leftPos := 0
 
 		c.root = binary.LittleEndian.Uint64(item.value[leftPos:])
 
 		leftPos += pageNumberSize
 		c.counter = binary.LittleEndian.Uint64(item.value[leftPos:])
+
+		leftPos += pageNumberSize
+		c.uncounted = len(item.value) < collectionSize
+
+		if !c.uncounted {
+			c.sequence = binary.LittleEndian.Uint64(item.value[leftPos:])
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of keys that hold a value, sub-collections are not counted. The number is maintained by Put
+// and Remove, only collections stored by an older version are counted once.
+func (c *Collection) Len() (int, error) {
+	if err := c.count(); err != nil {
+		return 0, err
+	}
+
+	return int(c.counter), nil
+}
+
+// NextSequence increments the sequence of the collection and returns it. The sequence starts at one and is stored
+// with the collection on commit, so it never returns the same number twice unless the transaction is rolled back.
+func (c *Collection) NextSequence() (uint64, error) {
+	if err := c.tx.checkWrite(); err != nil {
+		return 0, err
+	}
+
+	c.sequence++
+	c.dirty = true
+
+	return c.sequence, nil
+}
+
+// count counts the keys of the collection if its counter was not maintained.
+func (c *Collection) count() error {
+	if !c.uncounted {
+		return nil
 	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		return fmt.Errorf("failed to count keys: %w", err)
+	}
+
+	c.counter = uint64(stats.KeyCount)
+	c.uncounted = false
+
+	return nil
 }
 
 // getNodes returns a list of nodes based on their indexes (the breadcrumbs) from the root.
@@ -65,7 +141,7 @@ func (c *Collection) deserialize(item *Item) {
 // c       d   e     f
 // For [0,1,0] -> p,b,e.
 func (c *Collection) getNodes(indexes []int) ([]*node, error) {
-	root, err := c.dal.getNode(c.root)
+	root, err := c.tx.getNode(c.root)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
@@ -74,7 +150,7 @@ func (c *Collection) getNodes(indexes []int) ([]*node, error) {
 	child := root
 
 	for i := 1; i < len(indexes); i++ {
-		child, err = c.dal.getNode(child.childNodes[indexes[i]])
+		child, err = c.tx.getNode(child.childNodes[indexes[i]])
 		if err != nil {
 			return nil, err
 		}
@@ -85,48 +161,77 @@ func (c *Collection) getNodes(indexes []int) ([]*node, error) {
 	return nodes, nil
 }
 
-// Find Returns an item according based on the given key by performing a binary search.
+// Find Returns an item according based on the given key by performing a binary search. The tree is read through the
+// transaction, so uncommitted changes of a write transaction are visible. Nil is returned for a key that holds a
+// sub-collection.
 func (c *Collection) Find(key []byte) (*Item, error) {
-	n, err := c.dal.getNode(c.root)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get node: %w", err)
+	item, err := c.find(key)
+	if err != nil || item == nil || !item.collection {
+		return item, err
 	}
 
-	index, containingNode, _, err := n.findKey(key, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find key: %w", err)
+	return nil, nil //nolint:nilnil
+}
+
+// find returns the item with given key, which might hold a sub-collection.
+func (c *Collection) find(key []byte) (*Item, error) {
+	if err := c.tx.checkOpen(); err != nil {
+		return nil, err
 	}
 
-	if index == -1 {
-		return nil, nil //nolint:nilnil
+	item, err := c.tx.findItem(c.root, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key: %w", err)
 	}
 
-	return containingNode.items[index], nil
+	return item, nil
 }
 
 // Put adds a key to the tree. It finds the correct node and the insertion index and adds the item. When performing the
 // search, the ancestors are returned as well. This way we can iterate over them to check which nodes were modified and
 // rebalance by splitting them accordingly. If the root has too many items, then a new root of a new layer is
-// created and the created nodes from the split are added as children.
-func (c *Collection) Put(key []byte, value []byte) error { //nolint:funlen,cyclop
-	if !c.tx.write {
-		return ErrWriteInsideReadTx
+// created and the created nodes from the split are added as children. A key that holds a sub-collection can't be
+// overwritten.
+func (c *Collection) Put(key []byte, value []byte) error {
+	return c.put(NewItem(key, value))
+}
+
+// put adds given item to the tree, the item either holds a value or a sub-collection.
+func (c *Collection) put(newItem *Item) error { //nolint:funlen,cyclop
+	if err := c.tx.checkWrite(); err != nil {
+		return err
+	}
+
+	if len(newItem.key) > c.tx.db.maxKeySize() {
+		return ErrKeyTooLarge
+	}
+
+	if len(newItem.value) > maxValueSize {
+		return ErrValueTooLarge
+	}
+
+	if err := c.count(); err != nil {
+		return err
 	}
 
 	var (
-		newItem = NewItem(key, value)
-		root    *node
-		err     error
+		root *node
+		err  error
 	)
 
+	change := Change{Key: newItem.key, NewValue: newItem.value, Operation: OperationPut}
+
 	if c.root == 0 {
-		root = c.tx.writeNode(c.dal.newNode([]*Item{newItem}, []uint64{}))
+		c.dirty = true
+		root = c.tx.writeNode(c.tx.newNode([]*Item{newItem}, []uint64{}))
 		c.root = root.pageNumber
+		c.countItem(newItem, 1)
+		c.recordChange(newItem, change)
 
 		return nil
 	}
 
-	root, err = c.dal.getNode(c.root)
+	root, err = c.tx.getNode(c.root)
 	if err != nil {
 		return err
 	}
@@ -136,12 +241,26 @@ func (c *Collection) Put(key []byte, value []byte) error { //nolint:funlen,cyclo
 		return err
 	}
 
-	if nodeToInsertIn.items != nil && bytes.Equal(nodeToInsertIn.items[insertionIndex].key, key) {
+	replaced := insertionIndex < len(nodeToInsertIn.items) &&
+		bytes.Equal(nodeToInsertIn.items[insertionIndex].key, newItem.key)
+	if replaced && nodeToInsertIn.items[insertionIndex].collection != newItem.collection {
+		return ErrIncompatibleValue
+	}
+
+	c.dirty = true
+
+	if replaced {
+		change.OldValue = nodeToInsertIn.items[insertionIndex].value
+
+		c.tx.freeItem(nodeToInsertIn.items[insertionIndex])
 		nodeToInsertIn.items[insertionIndex] = newItem
 	} else {
 		nodeToInsertIn.addItem(newItem, insertionIndex)
+		c.countItem(newItem, 1)
 	}
 
+	c.recordChange(newItem, change)
+
 	c.tx.writeNode(nodeToInsertIn)
 
 	ancestors, err := c.getNodes(ancestorsIndexes)
@@ -159,9 +278,12 @@ func (c *Collection) Put(key []byte, value []byte) error { //nolint:funlen,cyclo
 		}
 	}
 
+	// ancestors of changed nodes are written as well, since they have to point to the new pages on commit
+	c.tx.writeNodes(ancestors...)
+
 	rootNode := ancestors[0]
 	if rootNode.isOverPopulated() {
-		newRoot := c.dal.newNode([]*Item{}, []uint64{rootNode.pageNumber})
+		newRoot := c.tx.newNode([]*Item{}, []uint64{rootNode.pageNumber})
 
 		newRoot.split(rootNode, 0)
 
@@ -177,13 +299,22 @@ func (c *Collection) Put(key []byte, value []byte) error { //nolint:funlen,cyclo
 // When performing the search, the ancestors are returned as well. This way we can iterate over them to check which
 // nodes were modified and rebalance by rotating or merging the unbalanced nodes. Rotation is done first. If the
 // siblings don't have enough items, then merging occurs. If the root is without items after a split, then the root is
-// removed and the tree is one level shorter.
-func (c *Collection) Remove(key []byte) error { //nolint:cyclop
-	if !c.tx.write {
-		return ErrWriteInsideReadTx
+// removed and the tree is one level shorter. A key that holds a sub-collection is removed by DeleteCollection instead.
+func (c *Collection) Remove(key []byte) error {
+	return c.remove(key, false)
+}
+
+// remove removes the item with given key, which has to hold a sub-collection if collection is set.
+func (c *Collection) remove(key []byte, collection bool) error { //nolint:cyclop
+	if err := c.tx.checkWrite(); err != nil {
+		return err
+	}
+
+	if c.root == 0 {
+		return nil
 	}
 
-	rootNode, err := c.dal.getNode(c.root)
+	rootNode, err := c.tx.getNode(c.root)
 	if err != nil {
 		return fmt.Errorf("failed to get node: %w", err)
 	}
@@ -197,6 +328,21 @@ func (c *Collection) Remove(key []byte) error { //nolint:cyclop
 		return nil
 	}
 
+	removedItem := nodeToRemoveFrom.items[removeItemIndex]
+	if removedItem.collection != collection {
+		return ErrIncompatibleValue
+	}
+
+	if err = c.count(); err != nil {
+		return err
+	}
+
+	c.dirty = true
+	c.countItem(removedItem, -1)
+
+	c.tx.freeItem(removedItem)
+	c.recordChange(removedItem, Change{Key: key, OldValue: removedItem.value, Operation: OperationRemove})
+
 	if nodeToRemoveFrom.isLeaf() {
 		nodeToRemoveFrom.removeItemFromLeaf(removeItemIndex)
 	} else {
@@ -215,11 +361,19 @@ func (c *Collection) Remove(key []byte) error { //nolint:cyclop
 		return fmt.Errorf("failed to get node: %w", err)
 	}
 
+	// Ancestors of changed nodes are written as well, since they have to point to the new pages on commit. They are
+	// written before rebalancing, so siblings that are read while rebalancing see the changes of the ancestors.
+	c.tx.writeNodes(ancestors...)
+
+	// Replacing an item by its predecessor or a separator by the item of a sibling can make a node bigger, so nodes
+	// are split if they are over populated and rebalanced if they are under populated.
 	for i := len(ancestors) - 2; i >= 0; i-- { //nolint:gomnd
 		pnode := ancestors[i]
 		node := ancestors[i+1]
 
-		if node.isUnderPopulated() {
+		if node.isOverPopulated() {
+			pnode.split(node, ancestorsIndexes[i+1])
+		} else if node.isUnderPopulated() {
 			err = pnode.rebalanceRemove(node, ancestorsIndexes[i+1])
 			if err != nil {
 				return fmt.Errorf("failed to rebalance node: %w", err)
@@ -228,9 +382,21 @@ func (c *Collection) Remove(key []byte) error { //nolint:cyclop
 	}
 
 	rootNode = ancestors[0]
-	if len(rootNode.items) == 0 && len(rootNode.childNodes) > 0 {
-		c.root = ancestors[1].pageNumber
+	if rootNode.isOverPopulated() {
+		newRoot := c.tx.newNode([]*Item{}, []uint64{rootNode.pageNumber})
+		newRoot.split(rootNode, 0)
+		c.root = c.tx.writeNode(newRoot).pageNumber
+	} else if len(rootNode.items) == 0 && len(rootNode.childNodes) > 0 {
+		c.root = rootNode.childNodes[0]
+		c.tx.deleteNode(rootNode)
 	}
 
 	return nil
 }
+
+// countItem adds given delta to the counter if given item holds a value.
+func (c *Collection) countItem(item *Item, delta int) {
+	if !item.collection {
+		c.counter += uint64(delta)
+	}
+}
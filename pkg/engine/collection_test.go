@@ -0,0 +1,514 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens a new database with given page size, which is closed when the test ends.
+func openTestDB(t *testing.T, pageSize int) *DB {
+	t.Helper()
+
+	db, err := Open(filepath.Join(t.TempDir(), "db"), &Options{PageSize: pageSize})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	return db
+}
+
+// checkTree fails the test if the tree of given collection violates an invariant of the B-tree.
+func checkTree(t *testing.T, collection *Collection) {
+	t.Helper()
+
+	if collection.root == 0 {
+		return
+	}
+
+	leafDepth := -1
+
+	var walk func(pageNumber uint64, depth int, lower []byte, upper []byte)
+
+	walk = func(pageNumber uint64, depth int, lower []byte, upper []byte) {
+		treeNode, err := collection.tx.getNode(pageNumber)
+		if err != nil {
+			t.Fatalf("failed to get node %d: %v", pageNumber, err)
+		}
+
+		if treeNode.isOverPopulated() {
+			t.Fatalf("node %d is over-populated with %d bytes", pageNumber, treeNode.size())
+		}
+
+		if pageNumber != collection.root && len(treeNode.items) == 0 {
+			t.Fatalf("node %d has no items", pageNumber)
+		}
+
+		if !treeNode.isLeaf() && len(treeNode.childNodes) != len(treeNode.items)+1 {
+			t.Fatalf("node %d has %d items and %d children", pageNumber, len(treeNode.items), len(treeNode.childNodes))
+		}
+
+		for i, item := range treeNode.items {
+			if (lower != nil && bytes.Compare(item.key, lower) <= 0) ||
+				(upper != nil && bytes.Compare(item.key, upper) >= 0) {
+				t.Fatalf("key %q of node %d is out of range (%q, %q)", item.key, pageNumber, lower, upper)
+			}
+
+			if i > 0 && bytes.Compare(treeNode.items[i-1].key, item.key) >= 0 {
+				t.Fatalf("keys of node %d are not ascending", pageNumber)
+			}
+		}
+
+		if treeNode.isLeaf() {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaf %d is at depth %d instead of %d", pageNumber, depth, leafDepth)
+			}
+
+			return
+		}
+
+		for i, childNode := range treeNode.childNodes {
+			childLower, childUpper := lower, upper
+
+			if i > 0 {
+				childLower = treeNode.items[i-1].key
+			}
+
+			if i < len(treeNode.items) {
+				childUpper = treeNode.items[i].key
+			}
+
+			walk(childNode, depth+1, childLower, childUpper)
+		}
+	}
+
+	walk(collection.root, 0, nil, nil)
+}
+
+// checkContent fails the test if given collection does not hold exactly the keys and values of given map.
+func checkContent(t *testing.T, collection *Collection, expected map[string][]byte) {
+	t.Helper()
+
+	count := 0
+
+	err := collection.Scan(nil, nil, func(item *Item) bool {
+		count++
+
+		value, ok := expected[string(item.Key())]
+		if !ok || !bytes.Equal(value, item.Value()) {
+			t.Errorf("unexpected item %q", item.Key())
+		}
+
+		return true
+	})
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+
+	if count != len(expected) {
+		t.Fatalf("collection has %d keys instead of %d", count, len(expected))
+	}
+
+	for key, value := range expected {
+		item, err := collection.Find([]byte(key))
+		if err != nil {
+			t.Fatalf("failed to find %q: %v", key, err)
+		}
+
+		if item == nil || !bytes.Equal(item.Value(), value) {
+			t.Fatalf("key %q has an unexpected value", key)
+		}
+	}
+}
+
+func TestRandomPutRemove(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pageSize     int
+		maxValueSize int
+	}{
+		{pageSize: 4096, maxValueSize: 900},
+		{pageSize: 512, maxValueSize: 150},
+		{pageSize: 4096, maxValueSize: 16},
+	}
+
+	for _, test := range tests {
+		for seed := int64(1); seed <= 5; seed++ {
+			test, seed := test, seed
+
+			t.Run(fmt.Sprintf("%d/%d/%d", test.pageSize, test.maxValueSize, seed), func(t *testing.T) {
+				t.Parallel()
+				randomPutRemove(t, test.pageSize, test.maxValueSize, seed)
+			})
+		}
+	}
+}
+
+// randomPutRemove puts and removes random keys in several transactions and checks the tree after every operation.
+func randomPutRemove(t *testing.T, pageSize int, maxValueSize int, seed int64) {
+	t.Helper()
+
+	random := rand.New(rand.NewSource(seed)) //nolint:gosec
+	expected := map[string][]byte{}
+	db := openTestDB(t, pageSize)
+
+	for round := 0; round < 20; round++ {
+		err := db.Update(func(tx *Transaction) error {
+			collection, err := tx.GetCollection([]byte("collection"))
+			if err == nil && collection == nil {
+				collection, err = tx.CreateCollection([]byte("collection"))
+			}
+
+			for i := 0; err == nil && i < 150; i++ {
+				key := []byte(fmt.Sprintf("key%0*d", random.Intn(8)+1, random.Intn(400)))
+
+				if random.Intn(3) == 0 {
+					err = collection.Remove(key)
+					delete(expected, string(key))
+				} else {
+					value := bytes.Repeat([]byte{byte(random.Intn(256))}, random.Intn(maxValueSize+1))
+					err = collection.Put(key, value)
+					expected[string(key)] = value
+				}
+
+				checkTree(t, collection)
+			}
+
+			if err == nil {
+				checkContent(t, collection, expected)
+			}
+
+			return err
+		})
+		if err != nil {
+			t.Fatalf("round %d failed: %v", round, err)
+		}
+	}
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err == nil {
+			checkTree(t, collection)
+			checkContent(t, collection, expected)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+}
+
+// putKeys puts given number of keys with values of given size into a new collection and commits them.
+func putKeys(t *testing.T, db *DB, count int, valueSize int) map[string][]byte {
+	t.Helper()
+
+	expected := map[string][]byte{}
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.CreateCollection([]byte("collection"))
+
+		for i := 0; err == nil && i < count; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			value := bytes.Repeat([]byte{byte(i)}, valueSize)
+			expected[string(key)] = value
+			err = collection.Put(key, value)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to put keys: %v", err)
+	}
+
+	return expected
+}
+
+// checkCommitted fails the test if the committed collection does not hold exactly the keys and values of given map.
+func checkCommitted(t *testing.T, db *DB, expected map[string][]byte) {
+	t.Helper()
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err == nil {
+			checkTree(t, collection)
+			checkContent(t, collection, expected)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+}
+
+func TestReadYourOwnWrites(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := putKeys(t, db, 300, 10)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		for i := 300; i < 600; i++ {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			expected[string(key)] = key
+
+			if err = collection.Put(key, key); err != nil {
+				return err
+			}
+
+			if item, findErr := collection.Find(key); findErr != nil || item == nil || !bytes.Equal(item.Value(), key) {
+				t.Fatalf("key %q put in the transaction was not found: %v", key, findErr)
+			}
+		}
+
+		for i := 0; i < 600; i += 3 {
+			key := []byte(fmt.Sprintf("key%05d", i))
+			delete(expected, string(key))
+
+			if err = collection.Remove(key); err != nil {
+				return err
+			}
+
+			if item, findErr := collection.Find(key); findErr != nil || item != nil {
+				t.Fatalf("key %q removed in the transaction was found: %v", key, findErr)
+			}
+		}
+
+		expected["key00001"] = []byte("overwritten")
+		if err = collection.Put([]byte("key00001"), []byte("overwritten")); err != nil {
+			return err
+		}
+
+		checkTree(t, collection)
+		checkContent(t, collection, expected)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	checkCommitted(t, db, expected)
+}
+
+// TestRemoveMergeWithCommittedParent removes keys from a committed tree, so the parents of merged nodes are read from
+// the file. The merged items were lost when merge read a stale copy of the parent.
+func TestRemoveMergeWithCommittedParent(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := putKeys(t, db, 2000, 10)
+	random := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+
+		for _, i := range random.Perm(2000)[:1500] {
+			if err != nil {
+				break
+			}
+
+			key := fmt.Sprintf("key%05d", i)
+			delete(expected, key)
+			err = collection.Remove([]byte(key))
+		}
+
+		if err == nil {
+			checkTree(t, collection)
+			checkContent(t, collection, expected)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to remove keys: %v", err)
+	}
+
+	checkCommitted(t, db, expected)
+}
+
+// TestRemoveMergeOverPopulated merges nodes of big items, which can exceed a page and have to be split again.
+func TestRemoveMergeOverPopulated(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := putKeys(t, db, 500, 100)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+
+		for i := 0; err == nil && i < 500; i += 3 {
+			key := fmt.Sprintf("key%05d", i)
+			delete(expected, key)
+			err = collection.Remove([]byte(key))
+
+			checkTree(t, collection)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to remove keys: %v", err)
+	}
+
+	checkCommitted(t, db, expected)
+}
+
+// TestRemoveFromInternalNode removes the items of the root while it has children, which replaces them by their
+// predecessors from the leaves of a tree with several levels.
+func TestRemoveFromInternalNode(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := putKeys(t, db, 3000, 10)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+
+		for i := 0; err == nil && i < 300; i++ {
+			var root *node
+
+			if root, err = tx.getNode(collection.root); err != nil || root.isLeaf() {
+				break
+			}
+
+			key := root.items[len(root.items)/2].key
+			delete(expected, string(key))
+			err = collection.Remove(key)
+
+			checkTree(t, collection)
+		}
+
+		if err == nil {
+			checkContent(t, collection, expected)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to remove keys: %v", err)
+	}
+
+	checkCommitted(t, db, expected)
+}
+
+// TestOverflowPagesFreed overwrites and removes a value stored on overflow pages. Its overflow pages have to be freed
+// by the commit, so overwriting the value repeatedly reuses them instead of growing the file.
+func TestOverflowPagesFreed(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 10, 3000)
+
+	maxPage := db.freelist.maxPage
+
+	for i := 0; i < 20; i++ {
+		overflowPages := findOverflowPages(t, db, "key00001")
+
+		update(t, db, func(collection *Collection) error {
+			return collection.Put([]byte("key00001"), bytes.Repeat([]byte{byte(i)}, 3000))
+		})
+
+		checkFree(t, db, overflowPages)
+	}
+
+	// all values were written once before, so the pages of the overwritten values are enough
+	if db.freelist.maxPage > maxPage+2*uint64(db.pagesFor(3000)) {
+		t.Fatalf("the file grew from %d to %d pages", maxPage+1, db.freelist.maxPage+1)
+	}
+
+	overflowPages := findOverflowPages(t, db, "key00002")
+
+	update(t, db, func(collection *Collection) error {
+		return collection.Remove([]byte("key00002"))
+	})
+
+	checkFree(t, db, overflowPages)
+}
+
+// update commits the changes of given function to the collection of putKeys.
+func update(t *testing.T, db *DB, change func(collection *Collection) error) {
+	t.Helper()
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		return change(collection)
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+}
+
+// findOverflowPages returns the overflow pages of the committed item with given key.
+func findOverflowPages(t *testing.T, db *DB, key string) []uint64 {
+	t.Helper()
+
+	var overflowPages []uint64
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		item, err := collection.Find([]byte(key))
+		if err != nil || item == nil || item.overflowPage == 0 {
+			t.Fatalf("%q is not stored on overflow pages: %v", key, err)
+		}
+
+		for i := 0; i < db.pagesFor(len(item.value)); i++ {
+			overflowPages = append(overflowPages, item.overflowPage+uint64(i))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+
+	return overflowPages
+}
+
+// checkFree fails the test if one of given pages is neither released nor pending.
+func checkFree(t *testing.T, db *DB, pageNumbers []uint64) {
+	t.Helper()
+
+	free := map[uint64]bool{}
+
+	for _, pageNumber := range db.freelist.releasedPages {
+		free[pageNumber] = true
+	}
+
+	for _, pending := range db.freelist.pendingPages {
+		for _, pageNumber := range pending {
+			free[pageNumber] = true
+		}
+	}
+
+	for _, pageNumber := range pageNumbers {
+		if !free[pageNumber] {
+			t.Fatalf("overflow page %d was not freed", pageNumber)
+		}
+	}
+}
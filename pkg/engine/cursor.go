@@ -0,0 +1,257 @@
+package engine
+
+import "fmt"
+
+// newCursor creates a new cursor for given collection.
+func newCursor(collection *Collection) *Cursor {
+	return &Cursor{
+		collection: collection,
+		stack:      make([]*cursorElement, 0),
+	}
+}
+
+// Cursor iterates over the items of a collection in key order. It reads through the transaction of the collection,
+// so it reflects uncommitted changes of a write transaction. Modifying the collection invalidates the position of the
+// cursor, it has to be positioned again with First, Last or Seek afterwards. Keys that hold a sub-collection are
+// returned as well, Item.IsCollection reports them and the sub-collection is opened by Collection.GetCollection.
+type Cursor struct {
+	collection *Collection
+	stack      []*cursorElement
+}
+
+// cursorElement is a node on the path from the root to the current item. The element on top of the stack points to
+// the current item, the elements below point to the child that was descended into.
+type cursorElement struct {
+	node  *node
+	index int
+}
+
+// Cursor creates a new cursor over the collection.
+func (c *Collection) Cursor() *Cursor {
+	return newCursor(c)
+}
+
+// First moves the cursor to the first item of the collection and returns it. Nil is returned if the collection is
+// empty.
+func (c *Cursor) First() (*Item, error) {
+	if err := c.reset(); err != nil {
+		return nil, err
+	}
+
+	if err := c.descendFirst(); err != nil {
+		return nil, err
+	}
+
+	return c.item(), nil
+}
+
+// Last moves the cursor to the last item of the collection and returns it. Nil is returned if the collection is empty.
+func (c *Cursor) Last() (*Item, error) {
+	if err := c.reset(); err != nil {
+		return nil, err
+	}
+
+	if err := c.descendLast(); err != nil {
+		return nil, err
+	}
+
+	return c.item(), nil
+}
+
+// Seek moves the cursor to the first item with a key equal to or greater than given key and returns it. Nil is
+// returned if there is no such item.
+func (c *Cursor) Seek(key []byte) (*Item, error) {
+	if err := c.reset(); err != nil {
+		return nil, err
+	}
+
+	for len(c.stack) > 0 {
+		top := c.top()
+
+		index, found := top.node.search(key)
+		top.index = index
+
+		if found {
+			return c.item(), nil
+		}
+
+		if top.node.isLeaf() {
+			// continue behind the last smaller item, which moves to the parent if the leaf has no greater item
+			top.index--
+
+			return c.Next()
+		}
+
+		if err := c.push(top.node.childNodes[top.index]); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// Next moves the cursor to the next item and returns it. Nil is returned if the cursor was on the last item.
+func (c *Cursor) Next() (*Item, error) {
+	if len(c.stack) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	top := c.top()
+
+	// the next item of an internal node is the first item of the subtree right of it
+	if !top.node.isLeaf() {
+		top.index++
+
+		if err := c.push(top.node.childNodes[top.index]); err != nil {
+			return nil, err
+		}
+
+		if err := c.descendFirst(); err != nil {
+			return nil, err
+		}
+
+		return c.item(), nil
+	}
+
+	top.index++
+	if top.index < len(top.node.items) {
+		return c.item(), nil
+	}
+
+	// the leaf is exhausted, so continue with the first ancestor that has an item right of the visited child
+	for c.pop(); len(c.stack) > 0; c.pop() {
+		if parent := c.top(); parent.index < len(parent.node.items) {
+			return c.item(), nil
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// Prev moves the cursor to the previous item and returns it. Nil is returned if the cursor was on the first item.
+func (c *Cursor) Prev() (*Item, error) {
+	if len(c.stack) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	top := c.top()
+
+	// the previous item of an internal node is the last item of the subtree left of it
+	if !top.node.isLeaf() {
+		if err := c.push(top.node.childNodes[top.index]); err != nil {
+			return nil, err
+		}
+
+		if err := c.descendLast(); err != nil {
+			return nil, err
+		}
+
+		return c.item(), nil
+	}
+
+	top.index--
+	if top.index >= 0 {
+		return c.item(), nil
+	}
+
+	// the leaf is exhausted, so continue with the first ancestor that has an item left of the visited child
+	for c.pop(); len(c.stack) > 0; c.pop() {
+		if parent := c.top(); parent.index > 0 {
+			parent.index--
+
+			return c.item(), nil
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// reset clears the stack and pushes the root of the collection, if the collection has one.
+func (c *Cursor) reset() error {
+	c.stack = c.stack[:0]
+
+	if c.collection.root == 0 {
+		return nil
+	}
+
+	return c.push(c.collection.root)
+}
+
+// descendFirst moves from the node on top of the stack down to the first item of its subtree.
+func (c *Cursor) descendFirst() error {
+	for len(c.stack) > 0 {
+		top := c.top()
+		top.index = 0
+
+		if top.node.isLeaf() {
+			return nil
+		}
+
+		if err := c.push(top.node.childNodes[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// descendLast moves from the node on top of the stack down to the last item of its subtree.
+func (c *Cursor) descendLast() error {
+	for len(c.stack) > 0 {
+		top := c.top()
+
+		if top.node.isLeaf() {
+			top.index = len(top.node.items) - 1
+
+			return nil
+		}
+
+		top.index = len(top.node.childNodes) - 1
+
+		if err := c.push(top.node.childNodes[top.index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// push reads the node with given page number and puts it on top of the stack.
+func (c *Cursor) push(pageNumber uint64) error {
+	if err := c.collection.tx.checkOpen(); err != nil {
+		return err
+	}
+
+	nextNode, err := c.collection.tx.getNode(pageNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+
+	c.stack = append(c.stack, &cursorElement{node: nextNode})
+
+	return nil
+}
+
+// pop removes the element on top of the stack.
+func (c *Cursor) pop() {
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+// top returns the element on top of the stack.
+func (c *Cursor) top() *cursorElement {
+	return c.stack[len(c.stack)-1]
+}
+
+// item returns the current item. Nil is returned if the cursor is not positioned on an item.
+func (c *Cursor) item() *Item {
+	if len(c.stack) == 0 {
+		return nil
+	}
+
+	top := c.top()
+	if top.index < 0 || top.index >= len(top.node.items) {
+		return nil
+	}
+
+	return top.node.items[top.index]
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorSeek(t *testing.T) {
+	t.Parallel()
+
+	db, err := Open(filepath.Join(t.TempDir(), "db"), &Options{PageSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	// seeking every key includes the separators of the internal nodes, an empty result means no item is found
+	expected := map[string]string{"": "key00000", "key00500-": "key00501", "key01000": ""}
+
+	err = db.Update(func(tx *Transaction) error {
+		collection, putErr := tx.CreateCollection([]byte("collection"))
+
+		for i := 0; putErr == nil && i < 1000; i++ {
+			key := fmt.Sprintf("key%05d", i)
+			expected[key] = key
+			putErr = collection.Put([]byte(key), []byte(key))
+		}
+
+		return putErr
+	})
+	if err != nil {
+		t.Fatalf("failed to put keys: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		for key, expectedKey := range expected {
+			item, err := collection.Cursor().Seek([]byte(key))
+			if err != nil {
+				return err
+			}
+
+			if (item == nil && expectedKey != "") || (item != nil && string(item.Key()) != expectedKey) {
+				t.Fatalf("seeking %q returned %v instead of %q", key, item, expectedKey)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to seek: %v", err)
+	}
+}
+
+// TestCursorPrev walks a collection backwards from the last item and forwards from the first item.
+func TestCursorPrev(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 1000, 10)
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		cursor := collection.Cursor()
+		item, err := cursor.Last()
+
+		for i := 999; i >= 0; i-- {
+			if err != nil {
+				return err
+			}
+
+			if key := fmt.Sprintf("key%05d", i); item == nil || string(item.Key()) != key {
+				t.Fatalf("the cursor returned %v instead of %q", item, key)
+			}
+
+			item, err = cursor.Prev()
+		}
+
+		if err != nil || item != nil {
+			t.Fatalf("the cursor returned %v, %v before the first item", item, err)
+		}
+
+		// the cursor moves forwards again from the first item
+		if item, err = cursor.First(); err == nil {
+			item, err = cursor.Next()
+		}
+
+		if err != nil || item == nil || string(item.Key()) != "key00001" {
+			t.Fatalf("the cursor returned %v, %v instead of the second item", item, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate: %v", err)
+	}
+}
+
+// TestCursorCollection iterates a collection with a sub-collection, which is returned without a value.
+func TestCursorCollection(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.CreateCollection([]byte("collection"))
+		if err == nil {
+			err = collection.Put([]byte("a"), []byte("value"))
+		}
+
+		if err == nil {
+			_, err = collection.CreateCollection([]byte("b"))
+		}
+
+		if err == nil {
+			err = collection.Put([]byte("c"), []byte("value"))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create collection: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		cursor := collection.Cursor()
+		items := make([]string, 0)
+		item, err := cursor.First()
+
+		for ; err == nil && item != nil; item, err = cursor.Next() {
+			items = append(items, fmt.Sprintf("%s:%t:%s", item.Key(), item.IsCollection(), item.Value()))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if fmt.Sprint(items) != "[a:false:value b:true: c:false:value]" {
+			t.Fatalf("the cursor returned %v", items)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate: %v", err)
+	}
+}
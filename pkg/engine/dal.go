@@ -1,8 +1,10 @@
 package engine
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -11,17 +13,24 @@ const (
 	fileMode           = os.FileMode(0o666)
 	minNodeFillPercent = 0.5
 	maxNodeFillPercent = 0.95
+	// maxInlineFraction defines the fraction of a page an item may take before its value is moved to overflow pages.
+	maxInlineFraction = 4
 )
 
 // newDal creates a new DAL for given file path.
-func newDal(path string) (*dal, error) {
+func newDal(path string, options *Options) (*dal, error) {
 	dal := &dal{
 		meta:     newEmptyMeta(),
 		freelist: newFreelist(),
-		pageSize: uint(os.Getpagesize()),
+		pageSize: uint(options.PageSize),
+		syncMode: options.SyncMode,
 	}
 	_, err := os.Stat(path)
 
+	if dal.pageSize == 0 {
+		dal.pageSize = uint(defaultPageSize())
+	}
+
 	switch {
 	case err == nil:
 		dal.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, fileMode)
@@ -31,13 +40,18 @@ func newDal(path string) (*dal, error) {
 			return nil, fmt.Errorf("failed to open file: %w", err)
 		}
 
+		dal.pageSize = dal.detectPageSize()
+
 		dal.meta, err = dal.readMeta()
-		if err != nil {
-			return nil, err
+		if err != nil && dal.isHeaderless() {
+			dal.meta, dal.freelist, err = dal.readHeaderless()
+		} else if err == nil {
+			dal.freelist, err = dal.readFreelist()
 		}
 
-		dal.freelist, err = dal.readFreelist()
 		if err != nil {
+			_ = dal.close()
+
 			return nil, err
 		}
 	case errors.Is(err, os.ErrNotExist):
@@ -48,19 +62,28 @@ func newDal(path string) (*dal, error) {
 			return nil, fmt.Errorf("failed to open file: %w", err)
 		}
 
+		dal.version = formatVersion
+		dal.meta.pageSize = uint32(dal.pageSize)
 		dal.freelistPageNumber = dal.getNextPage()
-		if err = dal.writeFreelist(); err != nil {
-			return nil, err
-		}
+		root := newEmptyNode()
+		root.pageNumber = dal.getNextPage()
+		dal.rootPageNumber = root.pageNumber
+
+		if err = dal.commit(
+			append(dal.newFreelistPages(dal.freelistPageNumber), dal.newNodePage(root)), dal.newMetaPage(*dal.meta),
+		); err != nil {
+			_ = dal.close()
 
-		// write meta page
-		if _, err = dal.writeMeta(*dal.meta); err != nil {
 			return nil, err
 		}
 	default:
 		return nil, fmt.Errorf("failed to get file state: %w", err)
 	}
 
+	if dal.syncMode == SyncInterval {
+		dal.syncer = newSyncer(options.SyncInterval, dal.sync)
+	}
+
 	return dal, nil
 }
 
@@ -69,11 +92,19 @@ type dal struct {
 	*meta
 	*freelist
 	file     *os.File
+	syncer   *syncer
 	pageSize uint
+	syncMode SyncMode
 }
 
 // Close closes the file.
 func (d *dal) close() error {
+	if d.syncer != nil {
+		if err := d.syncer.close(); err != nil {
+			return fmt.Errorf("failed to flush file: %w", err)
+		}
+	}
+
 	if d.file == nil {
 		return nil
 	}
@@ -85,14 +116,101 @@ func (d *dal) close() error {
 	return nil
 }
 
+// sync flushes the file to disk.
+func (d *dal) sync() error {
+	if err := d.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	return nil
+}
+
+// pageBodySize returns the usable size of a page behind the page header.
+func (d *dal) pageBodySize() uint {
+	return d.pageSize - pageHeaderSize
+}
+
+// pagesFor returns the number of consecutive pages required to store given amount of bytes.
+func (d *dal) pagesFor(size int) int {
+	bodySize := int(d.pageBodySize())
+
+	if size <= bodySize {
+		return 1
+	}
+
+	return (size + bodySize - 1) / bodySize
+}
+
+// maxPageCount returns the number of pages the file could hold at most.
+func (d *dal) maxPageCount() uint64 {
+	info, err := d.file.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return uint64(info.Size()) / uint64(d.pageSize)
+}
+
+// newPages creates consecutive pages starting at given number holding the given bytes.
+func (d *dal) newPages(number uint64, buffer []byte) []*page {
+	pages := make([]*page, 0, d.pagesFor(len(buffer)))
+
+	for pos := 0; pos == 0 || pos < len(buffer); pos += int(d.pageBodySize()) {
+		newPage := d.allocateEmptyPage()
+		newPage.number = number + uint64(len(pages))
+
+		copy(newPage.body(), buffer[pos:])
+		pages = append(pages, newPage)
+	}
+
+	return pages
+}
+
+// readPages reads given amount of bytes from the consecutive pages starting at given number.
+func (d *dal) readPages(number uint64, size int) ([]byte, error) {
+	buffer := make([]byte, 0, size)
+
+	for len(buffer) < size {
+		nextPage, err := d.readPage(number)
+		if err != nil {
+			return nil, err
+		}
+
+		body := nextPage.body()
+		if remaining := size - len(buffer); remaining < len(body) {
+			body = body[:remaining]
+		}
+
+		buffer = append(buffer, body...)
+		number++
+	}
+
+	return buffer, nil
+}
+
 // allocateEmptyPage creates a new page object with specified page size.
 func (d *dal) allocateEmptyPage() *page {
 	return newPage(d.pageSize)
 }
 
-// readPage reads a page with given number from file.
+// readPage reads a page with given number from file and verifies its checksum.
 func (d *dal) readPage(number uint64) (*page, error) {
+	allocatedPage, err := d.readRawPage(number)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = allocatedPage.verify(); err != nil {
+		return nil, err
+	}
+
+	return allocatedPage, nil
+}
+
+// readRawPage reads a page with given number from file without verifying its checksum.
+func (d *dal) readRawPage(number uint64) (*page, error) {
 	allocatedPage := d.allocateEmptyPage()
+	allocatedPage.number = number
 	offset := uint64(d.pageSize) * number
 
 	if _, err := d.file.ReadAt(allocatedPage.data, int64(offset)); err != nil {
@@ -102,10 +220,12 @@ func (d *dal) readPage(number uint64) (*page, error) {
 	return allocatedPage, nil
 }
 
-// writePage writes a page to file.
+// writePage writes a page to file after storing the checksum in its header.
 func (d *dal) writePage(pageToWrite page) error {
 	offset := uint64(d.pageSize) * pageToWrite.number
 
+	pageToWrite.seal()
+
 	if _, err := d.file.WriteAt(pageToWrite.data, int64(offset)); err != nil {
 		return fmt.Errorf("failed to write file [%d:%d]: %w", offset, d.pageSize, err)
 	}
@@ -113,88 +233,197 @@ func (d *dal) writePage(pageToWrite page) error {
 	return nil
 }
 
-// writeMeta writes given metadata to first page.
-func (d *dal) writeMeta(metadata meta) (*page, error) {
-	metaPage := d.allocateEmptyPage()
-	metaPage.number = metaPageNumber
+// writePages writes all given pages to file.
+func (d *dal) writePages(pagesToWrite []*page) error {
+	for _, pageToWrite := range pagesToWrite {
+		if err := d.writePage(*pageToWrite); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commit writes given pages and afterwards the meta page. Since pages of the previous commit are never overwritten
+// and the meta page alternates, a crash before the meta page is written completely leaves the previous commit intact.
+// Depending on the sync mode, the file is flushed after the pages and again after the meta page.
+func (d *dal) commit(pagesToCommit []*page, metaPage *page) error {
+	always := d.syncMode == SyncAlways
 
-	metadata.serialize(metaPage.data)
+	if d.syncer != nil {
+		if err := d.syncer.error(); err != nil {
+			return fmt.Errorf("failed to flush file in background: %w", err)
+		}
+	}
+
+	if err := d.writePages(pagesToCommit); err != nil {
+		return err
+	}
+
+	if always {
+		if err := d.sync(); err != nil {
+			return err
+		}
+	}
 
 	if err := d.writePage(*metaPage); err != nil {
-		return nil, fmt.Errorf("failed to write metadata page to file: %w", err)
+		return fmt.Errorf("failed to write metadata page to file: %w", err)
+	}
+
+	if always {
+		if err := d.sync(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newMetaPage creates the meta page holding given metadata.
+func (d *dal) newMetaPage(metadata meta) *page {
+	metaPage := d.allocateEmptyPage()
+	metaPage.number = metadata.pageNumber()
+
+	metadata.serialize(metaPage.body())
+
+	return metaPage
+}
+
+// detectPageSize returns the page size the file was created with. Since the meta pages have to be read to know the
+// page size, every supported page size is tried until a valid meta page of that size is found. Files of the legacy
+// format do not store the page size, they were written with the page size of the host.
+func (d *dal) detectPageSize() uint {
+	for pageSize := uint(minPageSize); pageSize <= maxPageSize; pageSize *= 2 {
+		candidate := &dal{file: d.file, pageSize: pageSize}
+
+		for number := metaPageNumber; number < metaPageNumber+metaPageCount; number++ {
+			metaPage, err := candidate.readRawPage(number)
+			if err != nil {
+				continue
+			}
+
+			metadata := newEmptyMeta()
+			if metadata.deserialize(metaPage.body()) != nil || uint(metadata.pageSize) != pageSize {
+				continue
+			}
+
+			if metaPage.verify() == nil {
+				return pageSize
+			}
+		}
 	}
 
-	return metaPage, nil
+	return uint(os.Getpagesize())
 }
 
-// readMeta reads metadata from first page.
+// readMeta reads the metadata of the latest commit. Meta pages that are corrupt, for example since they were only
+// partially written, are skipped. ErrNotADatabase is returned if none of the meta pages holds a meta.
 func (d *dal) readMeta() (*meta, error) {
-	metaPage, err := d.readPage(metaPageNumber)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read metadata page from file: %w", err)
+	var (
+		latest  *meta
+		lastErr = ErrNotADatabase
+	)
+
+	for number := metaPageNumber; number < metaPageNumber+metaPageCount; number++ {
+		metaPage, err := d.readRawPage(number)
+		if errors.Is(err, io.EOF) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read metadata page from file: %w", err)
+		}
+
+		metadata := newEmptyMeta()
+		if metadata.deserialize(metaPage.body()) != nil {
+			continue
+		}
+
+		if err = metaPage.verify(); err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		if latest == nil || metadata.txid > latest.txid {
+			latest = metadata
+		}
 	}
 
-	metadata := newEmptyMeta()
-	metadata.deserialize(metaPage.data)
+	if latest == nil {
+		return nil, fmt.Errorf("failed to read metadata page from file: %w", lastErr)
+	}
 
-	return metadata, nil
+	return latest, nil
 }
 
-// readFreelist reads and deserializes the freelist page.
+// readFreelist reads and deserializes the freelist pages. The size of the freelist is stored on its first page.
 func (d *dal) readFreelist() (*freelist, error) {
 	freelistPage, err := d.readPage(d.freelistPageNumber)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read freelist page from file: %w", err)
 	}
 
+	releasedPagesCount := binary.LittleEndian.Uint64(freelistPage.body()[pageNumberSize:])
+	if releasedPagesCount > d.maxPageCount() {
+		return nil, fmt.Errorf("failed to read freelist page %d: %w", d.freelistPageNumber, errMalformedFreelist)
+	}
+
+	size := newFreelist().size() + int(releasedPagesCount)*pageNumberSize
+
+	buffer, err := d.readPages(d.freelistPageNumber, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read freelist pages from file: %w", err)
+	}
+
 	freelist := newFreelist()
-	freelist.deserialize(freelistPage.data)
+	if err = freelist.deserialize(buffer); err != nil {
+		return nil, fmt.Errorf("failed to deserialize freelist page %d: %w", d.freelistPageNumber, err)
+	}
+
+	freelist.pageCount = d.pagesFor(size)
 
 	return freelist, nil
 }
 
-// writeFreelist serialized freelist and write to page.
-func (d *dal) writeFreelist() error {
-	freelistPage := d.allocateEmptyPage()
-	freelistPage.number = d.freelistPageNumber
+// newFreelistPages creates the consecutive freelist pages starting at given number holding the serialized freelist.
+func (d *dal) newFreelistPages(number uint64) []*page {
+	buffer := make([]byte, d.freelist.size())
 
-	d.freelist.serialize(freelistPage.data)
-
-	if err := d.writePage(*freelistPage); err != nil {
-		return fmt.Errorf("failed to write freelist page to file: %w", err)
-	}
+	d.freelist.serialize(buffer)
 
-	return nil
+	return d.newPages(number, buffer)
 }
 
 // getNode returns a node with given page number.
 func (d *dal) getNode(pageNumber uint64) (*node, error) {
+	if d.version == headerlessFormatVersion {
+		return d.getHeaderlessNode(pageNumber)
+	}
+
 	nodePage, err := d.readPage(pageNumber)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read node page from page %d: %w", pageNumber, err)
 	}
 
 	node := newEmptyNode()
-	node.deserialize(nodePage.data)
-	node.pageNumber = pageNumber
 
-	return node, nil
-}
+	if d.version < overflowFormatVersion {
+		err = node.deserializeLegacy(nodePage.body())
+	} else {
+		err = node.deserialize(nodePage.body(), d.readPages)
+	}
 
-// newNode creates a new node with given items and child nodes.
-func (d *dal) newNode(items []*Item, childNodes []uint64) *node {
-	newNode := newEmptyNode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize node page %d: %w", pageNumber, err)
+	}
 
-	newNode.items = items
-	newNode.childNodes = childNodes
-	newNode.pageNumber = d.getNextPage()
-	newNode.dal = d
+	node.pageNumber = pageNumber
+	node.dal = d
 
-	return newNode
+	return node, nil
 }
 
-// writeNode writes a node to file.
-func (d *dal) writeNode(nodeToWrite *node) (*page, error) {
+// newNodePage creates a page holding the serialized node. A page number is assigned to new nodes.
+func (d *dal) newNodePage(nodeToWrite *node) *page {
 	nodePage := d.allocateEmptyPage()
 
 	if nodeToWrite.pageNumber == 0 {
@@ -204,55 +433,71 @@ func (d *dal) writeNode(nodeToWrite *node) (*page, error) {
 		nodePage.number = nodeToWrite.pageNumber
 	}
 
-	nodePage.data = nodeToWrite.serialize(nodePage.data)
+	nodeToWrite.serialize(nodePage.body())
 
-	err := d.writePage(*nodePage)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write node page to file: %w", err)
-	}
-
-	return nodePage, nil
+	return nodePage
 }
 
-// writeNodes writes all given nodes to file.
-func (d *dal) writeNodes(nodesToWrite ...*node) error {
-	for i, nodeToWrite := range nodesToWrite {
-		if _, err := d.writeNode(nodeToWrite); err != nil {
-			return fmt.Errorf("failed to write nodes (on index %d): %w", i, err)
-		}
-	}
+// maxInlineSize returns the biggest size of key and value stored within a node, bigger values are stored on overflow
+// pages. This guarantees that every node can hold several items.
+func (d *dal) maxInlineSize() int {
+	return int(d.pageBodySize()) / maxInlineFraction
+}
 
-	return nil
+// maxKeySize returns the biggest supported key size, since keys are always stored within a node.
+func (d *dal) maxKeySize() int {
+	return d.maxInlineSize() - pageNumberSize - 2*binary.MaxVarintLen32 - byteOffset
 }
 
-// deleteNode delete a node on page with given number.
-func (d *dal) deleteNode(pageNumber uint64) {
-	d.releasePage(pageNumber)
+// overflows returns if the value of given item has to be stored on overflow pages.
+func (d *dal) overflows(item *Item) bool {
+	return item.size() > d.maxInlineSize()
 }
 
 // isOverPopulated returns if given node is over populated.
 func (d *dal) isOverPopulated(givenNode *node) bool {
-	return float32(givenNode.size()) > maxNodeFillPercent*float32(d.pageSize)
+	return float32(givenNode.size()) > maxNodeFillPercent*float32(d.pageBodySize())
 }
 
 // isUnderPopulated returns if given node is over under populated.
 func (d *dal) isUnderPopulated(givenNode *node) bool {
-	return float32(givenNode.size()) < minNodeFillPercent*float32(d.pageSize)
+	return float32(givenNode.size()) < minNodeFillPercent*float32(d.pageBodySize())
 }
 
-// getSplitIndex should be called when performing rebalance after an item is removed. It checks if a node can spare an
-// element, and if it does then it returns the index when there the split should happen. Otherwise -1 is returned.
+// getSplitIndex returns the index of the item that is moved to the parent when given node is split. The items in front
+// of it fill at least half of a page if possible, while both sides keep at least one item. -1 is returned if the node
+// has less than three items, which never exceed a page, since an item takes at most about a quarter of a page.
 func (d *dal) getSplitIndex(givenNode *node) int {
-	size := 0
-	size += nodeHeaderSize
+	if len(givenNode.items) < 3 { //nolint:gomnd
+		return -1
+	}
 
-	for index := range givenNode.items {
-		size += givenNode.items[index].size() + pageNumberSize
+	size := nodeHeaderSize + givenNode.elementSize(givenNode.items[0])
 
-		if float32(size) > (minNodeFillPercent*float32(d.pageSize)) && index < len(givenNode.items)-1 {
-			return index + 1
+	for index := 1; index < len(givenNode.items)-1; index++ {
+		if float32(size) > minNodeFillPercent*float32(d.pageBodySize()) {
+			return index
 		}
+
+		size += givenNode.elementSize(givenNode.items[index])
+	}
+
+	return len(givenNode.items) - 2 //nolint:gomnd
+}
+
+// canSpareItem returns if given node is still at least half full without the item at given index, so the item can be
+// rotated to a sibling.
+func (d *dal) canSpareItem(givenNode *node, index int) bool {
+	if len(givenNode.items) < 2 { //nolint:gomnd
+		return false
 	}
 
-	return -1
+	size := givenNode.size() - givenNode.elementSize(givenNode.items[index])
+
+	return float32(size) >= minNodeFillPercent*float32(d.pageBodySize())
+}
+
+// canHold returns if given item can be added to given node without over populating it.
+func (d *dal) canHold(givenNode *node, item *Item) bool {
+	return float32(givenNode.size()+givenNode.elementSize(item)) <= maxNodeFillPercent*float32(d.pageBodySize())
 }
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestOpenCorruptMeta damages the meta page of the latest commit like a crash while writing it. Open has to fall back
+// to the previous commit, which stays intact since its pages are not overwritten.
+func TestOpenCorruptMeta(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// damage damages given meta page, the page held the meta of an older commit before
+		damage func(metaPage []byte, older []byte)
+	}{
+		{name: "torn", damage: func(metaPage []byte, older []byte) {
+			copy(metaPage[pageHeaderSize+metaSize/2:], older[pageHeaderSize+metaSize/2:])
+		}},
+		{name: "corrupt", damage: func(metaPage []byte, _ []byte) { metaPage[pageHeaderSize+magicNumberSize] ^= 0xff }},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "db")
+
+			db, err := Open(path, &Options{PageSize: 512})
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+
+			expected := putKeys(t, db, 100, 10)
+			older := readFile(t, path)
+
+			update(t, db, func(collection *Collection) error {
+				return collection.Put([]byte("lost"), []byte("value"))
+			})
+
+			latest := db.meta.pageNumber()
+
+			if err = db.Close(); err != nil {
+				t.Fatalf("failed to close database: %v", err)
+			}
+
+			content := readFile(t, path)
+			test.damage(content[latest*512:(latest+1)*512], older[latest*512:(latest+1)*512])
+
+			if err = os.WriteFile(path, content, fileMode); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			db, err = Open(path, nil)
+			if err != nil {
+				t.Fatalf("failed to open damaged database: %v", err)
+			}
+
+			t.Cleanup(func() {
+				if err := db.Close(); err != nil {
+					t.Errorf("failed to close database: %v", err)
+				}
+			})
+
+			checkCommitted(t, db, expected)
+
+			// the damaged meta page is overwritten by the next commit
+			update(t, db, func(collection *Collection) error {
+				return collection.Put([]byte("key"), []byte("value"))
+			})
+
+			expected["key"] = []byte("value")
+			checkCommitted(t, db, expected)
+		})
+	}
+}
+
+// TestPageSizeRoundTrip reopens files created with a page size other than the default. The page size is read from the
+// file, regardless of the options.
+func TestPageSizeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, pageSize := range []int{512, 64 * 1024} {
+		pageSize := pageSize
+
+		t.Run(fmt.Sprint(pageSize), func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "db")
+
+			db, err := Open(path, &Options{PageSize: pageSize})
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+
+			expected := putKeys(t, db, 1000, 100)
+
+			if err = db.Close(); err != nil {
+				t.Fatalf("failed to close database: %v", err)
+			}
+
+			for _, options := range []*Options{nil, {PageSize: 1024}} {
+				if db, err = Open(path, options); err != nil {
+					t.Fatalf("failed to reopen database: %v", err)
+				}
+
+				if db.pageSize != uint(pageSize) {
+					t.Errorf("the page size %d was read instead of %d", db.pageSize, pageSize)
+				}
+
+				checkCommitted(t, db, expected)
+
+				if err = db.Close(); err != nil {
+					t.Fatalf("failed to close database: %v", err)
+				}
+			}
+
+			if size := fileSize(t, path); size%int64(pageSize) != 0 {
+				t.Fatalf("the file size %d is not a multiple of the page size", size)
+			}
+		})
+	}
+}
@@ -1,28 +1,80 @@
 package engine
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
 
-// DB is the interface of the database.
+// ErrTxPanicked is returned by DB.Update and DB.View if the transaction function panicked.
+var ErrTxPanicked = errors.New("transaction function panicked")
+
+// newDB creates a new database object for given DAL.
+func newDB(dal *dal, options *Options) *DB {
+	return &DB{
+		dal:           dal,
+		readers:       map[uint64]int{},
+		subscribers:   map[int]ChangeHandler{},
+		historyStart:  dal.txid,
+		writeLock:     make(chan struct{}, 1),
+		maxBatchSize:  options.maxBatchSize(),
+		maxBatchDelay: options.maxBatchDelay(),
+	}
+}
+
+// DB is the interface of the database. Read transactions read the snapshot of the commit that was the latest when they
+// started, so they neither block nor are blocked by the single write transaction.
 type DB struct {
 	*dal
-	rwlock sync.RWMutex
+	// readers counts the open read transactions per transaction id of their snapshot.
+	readers map[uint64]int
+	// writeLock allows a single write transaction at a time. It is a channel, so waiting for it can be cancelled.
+	writeLock chan struct{}
+	// batch collects the calls of DB.Batch until it is run.
+	batch *batch
+	// subscribers holds the handlers of the change feed.
+	subscribers map[int]ChangeHandler
+	// history holds the changes of the latest commits to resume watches from.
+	history []committedChanges
+	// metaLock guards the meta and the readers.
+	metaLock sync.Mutex
+	// batchLock guards the batch.
+	batchLock sync.Mutex
+	// feedLock guards the subscribers and the history.
+	feedLock       sync.Mutex
+	lastSubscriber int
+	// historyStart defines the transaction id after which the changes of all commits are retained.
+	historyStart  uint64
+	maxBatchSize  int
+	maxBatchDelay time.Duration
 }
 
-// Open the database for given path.
-func Open(path string) (*DB, error) {
+// Open the database for given path. If options is nil, the DefaultOptions are used.
+func Open(path string, options *Options) (*DB, error) {
 	var err error
 
-	dal, err := newDal(path)
+	if options == nil {
+		options = DefaultOptions()
+	}
+
+	if err = options.validate(); err != nil {
+		return nil, err
+	}
+
+	dal, err := newDal(path, options)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{
-		dal,
-		sync.RWMutex{},
+	if err = dal.checkFormat(); err != nil {
+		_ = dal.close()
+
+		return nil, err
 	}
 
-	return db, nil
+	return newDB(dal, options), nil
 }
 
 // Close closes the database.
@@ -30,16 +82,131 @@ func (db *DB) Close() error {
 	return db.dal.close()
 }
 
-// ReadTransaction create a new read transaction.
+// ReadTransaction create a new read transaction on the snapshot of the latest commit.
 func (db *DB) ReadTransaction() *Transaction {
-	db.rwlock.RLock()
-
-	return newTransaction(db, false)
+	return db.beginRead(context.Background())
 }
 
-// WriteTransaction create a new write transaction.
+// WriteTransaction create a new write transaction. It waits until the previous write transaction is finished.
 func (db *DB) WriteTransaction() *Transaction {
-	db.rwlock.Lock()
+	db.writeLock <- struct{}{}
+
+	return db.beginWrite(context.Background())
+}
+
+// BeginTx creates a new transaction bound to given context. It gives up waiting for the previous write transaction once
+// the context is done. Operations of the transaction and its commit fail with the error of the context once it is done.
+// If options is nil, a read transaction is created.
+func (db *DB) BeginTx(ctx context.Context, options *TxOptions) (*Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if options == nil || !options.Writable {
+		return db.beginRead(ctx), nil
+	}
+
+	select {
+	case db.writeLock <- struct{}{}:
+		return db.beginWrite(ctx), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// beginRead creates a new read transaction on the snapshot of the latest commit.
+func (db *DB) beginRead(ctx context.Context) *Transaction {
+	db.metaLock.Lock()
+	defer db.metaLock.Unlock()
+
+	db.readers[db.txid]++
+
+	return newTransaction(ctx, db, *db.meta, false)
+}
+
+// beginWrite creates a new write transaction, the write lock has to be held already.
+func (db *DB) beginWrite(ctx context.Context) *Transaction {
+	db.metaLock.Lock()
+	defer db.metaLock.Unlock()
+
+	// pages freed by older commits can be reused once no read transaction can see them anymore
+	db.freelist.releasePending(db.oldestReader())
+
+	tx := newTransaction(ctx, db, *db.meta, true)
+	tx.freelist = db.freelist.snapshot()
+
+	return tx
+}
+
+// Update runs given function within a write transaction. The transaction is committed if the function returns nil and
+// rolled back otherwise. A panic of the function rolls the transaction back and is returned as ErrTxPanicked. The
+// transaction must not be committed or rolled back by the function.
+func (db *DB) Update(fn func(tx *Transaction) error) error {
+	return db.managed(db.WriteTransaction(), fn)
+}
+
+// View runs given function within a read transaction, which is closed when the function returns. A panic of the
+// function is returned as ErrTxPanicked.
+func (db *DB) View(fn func(tx *Transaction) error) error {
+	return db.managed(db.ReadTransaction(), fn)
+}
+
+// managed runs given function within given transaction and commits or rolls back the transaction depending on the
+// result of the function.
+func (db *DB) managed(tx *Transaction, fn func(tx *Transaction) error) error {
+	tx.managed = true
+
+	if err := callSafely(fn, tx); err != nil {
+		tx.rollback()
+
+		return err
+	}
+
+	return tx.commit()
+}
+
+// callSafely calls given function with given transaction and returns a panic of the function as ErrTxPanicked.
+func callSafely(fn func(tx *Transaction) error, tx *Transaction) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%w: %v", ErrTxPanicked, recovered)
+		}
+	}()
+
+	return fn(tx)
+}
+
+// closeReadTransaction unregisters a read transaction on the snapshot with given transaction id.
+func (db *DB) closeReadTransaction(txid uint64) {
+	db.metaLock.Lock()
+	defer db.metaLock.Unlock()
+
+	if db.readers[txid]--; db.readers[txid] <= 0 {
+		delete(db.readers, txid)
+	}
+}
+
+// oldestReader returns the transaction id of the oldest snapshot that is read, which is the latest commit if no read
+// transaction is open.
+func (db *DB) oldestReader() uint64 {
+	oldest := db.txid
+
+	for txid := range db.readers {
+		if txid < oldest {
+			oldest = txid
+		}
+	}
+
+	return oldest
+}
+
+// publish makes given meta the latest commit for new transactions. The format fields are not changed while the
+// database is open and therefore not assigned, since nodes are decoded based on them without locking.
+func (db *DB) publish(metadata *meta) {
+	db.metaLock.Lock()
+	defer db.metaLock.Unlock()
 
-	return newTransaction(db, true)
+	db.txid = metadata.txid
+	db.rootPageNumber = metadata.rootPageNumber
+	db.freelistPageNumber = metadata.freelistPageNumber
 }
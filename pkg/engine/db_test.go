@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestSnapshotIsolation commits changes while a read transaction is open. The read transaction has to keep reading its
+// snapshot and the pages of the snapshot must not be reused until it is closed.
+func TestSnapshotIsolation(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := putKeys(t, db, 500, 10)
+
+	reader := db.ReadTransaction()
+
+	collection, err := reader.GetCollection([]byte("collection"))
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+
+	snapshotPages := treePages(t, reader, collection.root)
+
+	for i := 0; i < 10; i++ {
+		update(t, db, func(collection *Collection) error {
+			for key := range expected {
+				if err := collection.Put([]byte(key), bytes.Repeat([]byte{byte(i)}, 20)); err != nil {
+					return err
+				}
+			}
+
+			return collection.Remove([]byte(fmt.Sprintf("key%05d", i)))
+		})
+
+		// every page of the snapshot was freed by the first commit and has to stay pending
+		pending := map[uint64]bool{}
+
+		for _, pageNumbers := range db.freelist.pendingPages {
+			for _, pageNumber := range pageNumbers {
+				pending[pageNumber] = true
+			}
+		}
+
+		for _, pageNumber := range snapshotPages {
+			if !pending[pageNumber] {
+				t.Fatalf("page %d of the snapshot is not pending after commit %d", pageNumber, i)
+			}
+		}
+	}
+
+	checkContent(t, collection, expected)
+	reader.Rollback()
+
+	// the next write transaction releases the pages, since no read transaction sees them anymore
+	update(t, db, func(collection *Collection) error {
+		return collection.Put([]byte("key"), []byte("value"))
+	})
+
+	released := map[uint64]bool{}
+
+	for _, pageNumber := range db.freelist.releasedPages {
+		released[pageNumber] = true
+	}
+
+	reused := 0
+
+	for _, pageNumber := range snapshotPages {
+		if !released[pageNumber] {
+			reused++
+		}
+	}
+
+	if reused == 0 {
+		t.Fatal("no page of the snapshot was reused after the read transaction was closed")
+	}
+}
+
+// treePages returns the page numbers of the nodes of the tree with given root, as read by given transaction.
+func treePages(t *testing.T, tx *Transaction, root uint64) []uint64 {
+	t.Helper()
+
+	treeNode, err := tx.getNode(root)
+	if err != nil {
+		t.Fatalf("failed to get node %d: %v", root, err)
+	}
+
+	pageNumbers := []uint64{root}
+
+	for _, childNode := range treeNode.childNodes {
+		pageNumbers = append(pageNumbers, treePages(t, tx, childNode)...)
+	}
+
+	return pageNumbers
+}
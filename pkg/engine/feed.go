@@ -0,0 +1,92 @@
+package engine
+
+// Operation defines the kind of a change.
+type Operation int
+
+const (
+	// OperationPut is a key that was added or overwritten by Collection.Put.
+	OperationPut Operation = iota
+	// OperationRemove is a key that was removed by Collection.Remove.
+	OperationRemove
+)
+
+// Change describes a change of a key by a committed transaction.
+type Change struct {
+	// Collection defines the name of the collection of the key.
+	Collection []byte
+	// Parents holds the names of the collections that contain the collection of the key, starting with the outermost.
+	// It is empty for the collections of a transaction.
+	Parents [][]byte
+	Key     []byte
+	// OldValue holds the value before the change, which is nil if the key did not exist.
+	OldValue []byte
+	// NewValue holds the value after the change, which is nil if the key was removed.
+	NewValue  []byte
+	Operation Operation
+}
+
+// ChangeHandler is called with the transaction id and the changes of a commit.
+type ChangeHandler func(txid uint64, changes []Change)
+
+// Subscribe registers given handler for the change feed of the database. The handler is called after every commit
+// that changed keys, in commit order and before the next write transaction starts. It must therefore not start a write
+// transaction itself. The returned function unsubscribes the handler.
+func (db *DB) Subscribe(handler ChangeHandler) func() {
+	db.feedLock.Lock()
+	defer db.feedLock.Unlock()
+
+	db.lastSubscriber++
+	id := db.lastSubscriber
+	db.subscribers[id] = handler
+
+	return func() {
+		db.feedLock.Lock()
+		defer db.feedLock.Unlock()
+
+		delete(db.subscribers, id)
+	}
+}
+
+// notify passes the changes of the commit with given transaction id to the subscribers.
+func (db *DB) notify(txid uint64, changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	db.feedLock.Lock()
+	db.retain(txid, changes)
+
+	handlers := make([]ChangeHandler, 0, len(db.subscribers))
+
+	for _, handler := range db.subscribers {
+		handlers = append(handlers, handler)
+	}
+
+	db.feedLock.Unlock()
+
+	for _, handler := range handlers {
+		handler(txid, changes)
+	}
+}
+
+// recordChange records given change of given item of the collection, which is passed to the change feed on commit.
+// Changes of sub-collections and of the root collection are not recorded, since they store collections instead of keys.
+func (c *Collection) recordChange(item *Item, change Change) {
+	if item.collection || c == c.tx.rootCollection {
+		return
+	}
+
+	change.Collection = c.name
+
+	for parent := c.parent; parent != c.tx.rootCollection; parent = parent.parent {
+		change.Parents = append([][]byte{parent.name}, change.Parents...)
+	}
+
+	c.tx.changes = append(c.tx.changes, change)
+}
+
+// recordRemove records the removal of given item of the collection, which is passed to free when all keys of the
+// collection are removed.
+func (c *Collection) recordRemove(item *Item) {
+	c.recordChange(item, Change{Key: item.key, OldValue: item.value, Operation: OperationRemove})
+}
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestDeleteCollectionChanges deletes a collection, which has to report the removal of every key like truncating it.
+// The keys of the collections within it are reported together with their parents.
+func TestDeleteCollectionChanges(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	expected := map[string][]byte{}
+
+	for key, value := range putKeys(t, db, 300, 10) {
+		expected["collection/"+key] = value
+	}
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		path := "collection/"
+
+		for _, name := range []string{"nested", "deep"} {
+			if err == nil {
+				collection, err = collection.CreateCollection([]byte(name))
+				path += name + "/"
+			}
+
+			for i := 0; i < 100 && err == nil; i++ {
+				key := fmt.Sprintf("%s%05d", name, i)
+				expected[path+key] = []byte(key)
+				err = collection.Put([]byte(key), []byte(key))
+			}
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create sub-collections: %v", err)
+	}
+
+	removed := map[string][]byte{}
+
+	unsubscribe := db.Subscribe(func(_ uint64, changes []Change) {
+		for _, change := range changes {
+			if change.Operation != OperationRemove || change.NewValue != nil {
+				t.Errorf("unexpected change of %q in %q", change.Key, change.Collection)
+			}
+
+			path := make([][]byte, 0, len(change.Parents)+2)
+			path = append(append(append(path, change.Parents...), change.Collection), change.Key)
+			removed[string(bytes.Join(path, []byte("/")))] = change.OldValue
+		}
+	})
+	defer unsubscribe()
+
+	err = db.Update(func(tx *Transaction) error {
+		return tx.DeleteCollection([]byte("collection"))
+	})
+	if err != nil {
+		t.Fatalf("failed to delete collection: %v", err)
+	}
+
+	if len(removed) != len(expected) {
+		t.Fatalf("%d removed keys were reported instead of %d", len(removed), len(expected))
+	}
+
+	for key, value := range expected {
+		if !bytes.Equal(removed[key], value) {
+			t.Fatalf("the removal of %q was not reported with its old value", key)
+		}
+	}
+}
+
+// TestFeedRolledBack checks that the change feed is not notified of rolled back transactions, failed commits and
+// changes undone by rolling back to a savepoint.
+func TestFeedRolledBack(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 10, 10)
+
+	notified := make([]string, 0)
+
+	unsubscribe := db.Subscribe(func(_ uint64, changes []Change) {
+		for _, change := range changes {
+			notified = append(notified, string(change.Key))
+		}
+	})
+	defer unsubscribe()
+
+	errRollback := errors.New("rollback")
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err == nil {
+			err = collection.Put([]byte("rolled back"), []byte("value"))
+		}
+
+		if err == nil {
+			err = errRollback
+		}
+
+		return err
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("expected the transaction to be rolled back, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tx, err := db.BeginTx(ctx, &TxOptions{Writable: true})
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+
+	failed, err := tx.GetCollection([]byte("collection"))
+	if err == nil {
+		err = failed.Put([]byte("failed"), []byte("value"))
+	}
+
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	cancel()
+
+	if err = tx.Commit(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the commit to fail, got %v", err)
+	}
+
+	err = db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		if err = collection.Put([]byte("before"), []byte("value")); err != nil {
+			return err
+		}
+
+		savepoint, err := tx.Savepoint()
+		if err != nil {
+			return err
+		}
+
+		if err = collection.Put([]byte("undone"), []byte("value")); err != nil {
+			return err
+		}
+
+		if err = savepoint.Rollback(); err != nil {
+			return err
+		}
+
+		return collection.Put([]byte("after"), []byte("value"))
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	if fmt.Sprint(notified) != "[before after]" {
+		t.Fatalf("the feed was notified of %v instead of [before after]", notified)
+	}
+}
@@ -1,28 +1,41 @@
 package engine
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+var errMalformedFreelist = fmt.Errorf("%w: freelist exceeds its page", ErrCorrupt)
 
 // newFreelist creates a new freelist object.
 func newFreelist() *freelist {
 	return &freelist{
-		maxPage:       metaPageNumber,
+		pendingPages:  map[uint64][]uint64{},
+		maxPage:       metaPageNumber + metaPageCount - 1,
 		releasedPages: []uint64{},
+		pageCount:     1,
 	}
 }
 
 // freelist helps to organize pages by tracing the last and freed pages.
 // This is important to reuse freed pages and to avoid fragmentation.
+// The freelist is stored on as many consecutive pages as its size requires. Released pages are kept sorted and single
+// pages are taken from the low end, so pages freed together remain consecutive for allocations of several pages.
+// Pages freed by a commit are pending until no read transaction of an older commit is open, since those still read
+// them. Pending pages are stored as released pages, because no read transaction survives a restart.
 type freelist struct {
+	pendingPages  map[uint64][]uint64
 	releasedPages []uint64
 	maxPage       uint64
+	pageCount     int
 }
 
-// getNextPage returns a freed page number or a new one if no freed pages exist.
+// getNextPage returns the lowest freed page number or a new one if no freed pages exist.
 func (f *freelist) getNextPage() uint64 {
 	if len(f.releasedPages) > 0 {
-		index := len(f.releasedPages) - 1
-		pageNumber := f.releasedPages[index]
-		f.releasedPages = f.releasedPages[:index]
+		pageNumber := f.releasedPages[0]
+		f.releasedPages = f.releasedPages[1:]
 
 		return pageNumber
 	}
@@ -32,9 +45,94 @@ func (f *freelist) getNextPage() uint64 {
 	return f.maxPage
 }
 
-// releasePage marks given page number as freed.
-func (f *freelist) releasePage(number uint64) {
-	f.releasedPages = append(f.releasedPages, number)
+// allocate returns the first page number of count consecutive pages. Freed pages are reused if enough of them are
+// consecutive, otherwise new pages are appended.
+func (f *freelist) allocate(count int) uint64 {
+	if count == 1 {
+		return f.getNextPage()
+	}
+
+	for i := 0; i+count <= len(f.releasedPages); i++ {
+		first := f.releasedPages[i]
+
+		if f.releasedPages[i+count-1]-first == uint64(count-1) {
+			f.releasedPages = append(f.releasedPages[:i], f.releasedPages[i+count:]...)
+
+			return first
+		}
+	}
+
+	first := f.maxPage + 1
+	f.maxPage += uint64(count)
+
+	return first
+}
+
+// snapshot returns a copy of the released pages and the page counters, which can be restored to undo allocations.
+// Pending pages are not part of the snapshot, since they only change on commit.
+func (f *freelist) snapshot() *freelist {
+	return &freelist{
+		releasedPages: append([]uint64{}, f.releasedPages...),
+		maxPage:       f.maxPage,
+		pageCount:     f.pageCount,
+	}
+}
+
+// restore resets the released pages and the page counters to given snapshot.
+func (f *freelist) restore(snapshot *freelist) {
+	f.releasedPages = append([]uint64{}, snapshot.releasedPages...)
+	f.maxPage = snapshot.maxPage
+	f.pageCount = snapshot.pageCount
+}
+
+// free marks given page numbers as freed by the commit with given transaction id.
+func (f *freelist) free(txid uint64, pageNumbers []uint64) {
+	f.pendingPages[txid] = append(f.pendingPages[txid], pageNumbers...)
+}
+
+// releasePending releases the pages freed by the commits up to given transaction id.
+func (f *freelist) releasePending(txid uint64) {
+	released := false
+
+	for pendingTxid, pageNumbers := range f.pendingPages {
+		if pendingTxid <= txid {
+			f.releasedPages = append(f.releasedPages, pageNumbers...)
+			delete(f.pendingPages, pendingTxid)
+
+			released = true
+		}
+	}
+
+	if released {
+		f.sort()
+	}
+}
+
+// sort sorts the released pages in ascending order.
+func (f *freelist) sort() {
+	sort.Slice(f.releasedPages, func(i, j int) bool { return f.releasedPages[i] < f.releasedPages[j] })
+}
+
+// rollbackPending forgets the pages freed by the commit with given transaction id, since the commit failed and the
+// pages are still in use.
+func (f *freelist) rollbackPending(txid uint64) {
+	delete(f.pendingPages, txid)
+}
+
+// count returns the number of released and pending pages.
+func (f *freelist) count() int {
+	count := len(f.releasedPages)
+
+	for _, pageNumbers := range f.pendingPages {
+		count += len(pageNumbers)
+	}
+
+	return count
+}
+
+// size returns the size of the serialized freelist in bytes.
+func (f *freelist) size() int {
+	return 2*pageNumberSize + f.count()*pageNumberSize //nolint:gomnd
 }
 
 // serialize serializes the freelist object into byte array.
@@ -45,7 +143,7 @@ func (f *freelist) serialize(buffer []byte) []byte {
 	pos += pageNumberSize
 
 	// released pages count
-	binary.LittleEndian.PutUint64(buffer[pos:], uint64(len(f.releasedPages)))
+	binary.LittleEndian.PutUint64(buffer[pos:], uint64(f.count()))
 	pos += pageNumberSize
 
 	for _, page := range f.releasedPages {
@@ -53,12 +151,24 @@ func (f *freelist) serialize(buffer []byte) []byte {
 		pos += pageNumberSize
 	}
 
+	for _, pageNumbers := range f.pendingPages {
+		for _, page := range pageNumbers {
+			binary.LittleEndian.PutUint64(buffer[pos:], page)
+			pos += pageNumberSize
+		}
+	}
+
 	return buffer
 }
 
 // deserialize deserializes the byte array to freelist object.
-func (f *freelist) deserialize(buf []byte) {
+func (f *freelist) deserialize(buf []byte) error {
 	pos := 0
+
+	if len(buf) < 2*pageNumberSize {
+		return errMalformedFreelist
+	}
+
 	f.maxPage = binary.LittleEndian.Uint64(buf[pos:])
 	pos += pageNumberSize
 
@@ -66,8 +176,17 @@ func (f *freelist) deserialize(buf []byte) {
 	releasedPagesCount := binary.LittleEndian.Uint64(buf[pos:])
 	pos += pageNumberSize
 
+	if releasedPagesCount > uint64(len(buf[pos:])/pageNumberSize) {
+		return errMalformedFreelist
+	}
+
 	for i := uint64(0); i < releasedPagesCount; i++ {
 		f.releasedPages = append(f.releasedPages, binary.LittleEndian.Uint64(buf[pos:]))
 		pos += pageNumberSize
 	}
+
+	// pending pages are stored after the released pages
+	f.sort()
+
+	return nil
 }
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recreateCollection deletes the collection of putKeys and puts given number of keys into a new one within one commit.
+func recreateCollection(t *testing.T, db *DB, count int) {
+	t.Helper()
+
+	err := db.Update(func(tx *Transaction) error {
+		if err := tx.DeleteCollection([]byte("collection")); err != nil {
+			return err
+		}
+
+		collection, err := tx.CreateCollection([]byte("collection"))
+
+		for i := 0; err == nil && i < count; i++ {
+			err = collection.Put([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to recreate collection: %v", err)
+	}
+}
+
+// TestFreelistSteadyState recreates a collection in every commit. Single pages were taken from the released pages in
+// the order they were released, which broke up the runs of consecutive pages the freelist needs. The freelist was
+// appended to the file on every commit instead.
+func TestFreelistSteadyState(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 2000, 5)
+
+	// the first commits release the pages of the initial tree, afterwards the file has to keep its size
+	for i := 0; i < 5; i++ {
+		recreateCollection(t, db, 2000)
+	}
+
+	maxPage := db.freelist.maxPage
+
+	for i := 0; i < 50; i++ {
+		recreateCollection(t, db, 2000)
+
+		if db.freelist.maxPage != maxPage {
+			t.Fatalf("the file grew from %d to %d pages in commit %d", maxPage+1, db.freelist.maxPage+1, i)
+		}
+	}
+}
+
+func TestFreelistAllocate(t *testing.T) {
+	t.Parallel()
+
+	list := newFreelist()
+	list.maxPage = 20
+	list.free(1, []uint64{9, 3, 12, 4, 5})
+	list.free(2, []uint64{10, 11, 6})
+	list.releasePending(2)
+
+	// single pages are taken from the low end, so the pages from 9 to 12 stay consecutive
+	if first, second := list.allocate(1), list.allocate(1); first != 3 || second != 4 {
+		t.Fatalf("single pages %d and %d were allocated instead of 3 and 4", first, second)
+	}
+
+	if first := list.allocate(4); first != 9 {
+		t.Fatalf("4 consecutive pages were allocated at %d instead of 9", first)
+	}
+
+	if first := list.allocate(3); first != 21 || list.maxPage != 23 {
+		t.Fatalf("3 consecutive pages were allocated at %d instead of appending them", first)
+	}
+
+	if list.count() != 2 {
+		t.Fatalf("%d pages are free instead of 2", list.count())
+	}
+}
@@ -1,16 +1,44 @@
 package engine
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
 
 const (
 	// magicNumber define the file type for this database.
 	magicNumber uint32 = 0xD00DB00D
-	// metaPageNumber defines the page number for the meta page.
+	// metaPageNumber defines the page number for the first of the meta pages.
 	metaPageNumber = uint64(0)
+	// metaPageCount defines the number of meta pages the commits alternate between.
+	metaPageCount = 2
 	// metaPageNumber defines the size of a page number in bytes.
 	pageNumberSize = 8
 	// magicNumber defines the size of the magic number.
 	magicNumberSize = 4
+	// checksumSize defines the size of a checksum.
+	checksumSize = 4
+	// metaSize defines the size of the serialized meta.
+	metaSize = magicNumberSize + 3*pageNumberSize + 3*int32Offset
+	// formatVersion defines the version of the file format written by this implementation.
+	formatVersion uint32 = 3
+	// headerlessFormatVersion defines the version of files written before pages had a header. They had a single meta
+	// page, which was followed by the freelist page.
+	headerlessFormatVersion uint32 = 0
+	// legacyFormatVersion defines the version of files written before the format version was stored in the meta.
+	legacyFormatVersion uint32 = 1
+	// overflowFormatVersion defines the version that introduced varint lengths and overflow pages in nodes.
+	overflowFormatVersion uint32 = 3
+	// supportedFeatureFlags defines the feature flags this implementation can handle.
+	supportedFeatureFlags uint32 = 0
+)
+
+var (
+	// ErrNotADatabase is returned if the file is not a database file.
+	ErrNotADatabase = errors.New("the file is not a db file")
+	// ErrUnsupportedVersion is returned if the database file was written in a format version that is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported database format version")
 )
 
 // newEmptyMeta creates a new meta object.
@@ -19,13 +47,27 @@ func newEmptyMeta() *meta {
 }
 
 //nolint:godot
-// meta is the first page of a database file and holds meta for the database as:
+// meta is stored on the first two pages of a database file and holds meta for the database as:
 /*
+ * transaction id
+ * root collection
  * freelist meta
+ * format version, page size and feature flags
  */
+// Commits alternate between both pages, so the meta of the previous commit stays intact until the new one is written
+// completely. The page checksum reveals a meta page that was only partially written.
 type meta struct {
+	txid               uint64
 	freelistPageNumber uint64
 	rootPageNumber     uint64
+	version            uint32
+	pageSize           uint32
+	flags              uint32
+}
+
+// pageNumber returns the page the meta is written to.
+func (m *meta) pageNumber() uint64 {
+	return metaPageNumber + m.txid%metaPageCount
 }
 
 // serialize given byte array.
@@ -35,24 +77,77 @@ func (m *meta) serialize(buffer []byte) {
 	binary.LittleEndian.PutUint32(buffer[pos:], magicNumber)
 	pos += magicNumberSize
 
-	binary.LittleEndian.PutUint64(buffer[pos:], m.rootPageNumber)
+	binary.LittleEndian.PutUint64(buffer[pos:], m.txid)
+	pos += pageNumberSize
 
+	binary.LittleEndian.PutUint64(buffer[pos:], m.rootPageNumber)
 	pos += pageNumberSize
+
 	binary.LittleEndian.PutUint64(buffer[pos:], m.freelistPageNumber)
+	pos += pageNumberSize
+
+	binary.LittleEndian.PutUint32(buffer[pos:], m.version)
+	pos += int32Offset
+
+	binary.LittleEndian.PutUint32(buffer[pos:], m.pageSize)
+	pos += int32Offset
+
+	binary.LittleEndian.PutUint32(buffer[pos:], m.flags)
 }
 
 // deserialize to given byte array.
-func (m *meta) deserialize(buffer []byte) {
+func (m *meta) deserialize(buffer []byte) error {
 	pos := 0
 
-	magicNumberRes := binary.LittleEndian.Uint32(buffer[pos:])
-	if magicNumberRes != magicNumber {
-		panic("The file is not a db file")
+	if !isValidMeta(buffer) {
+		return ErrNotADatabase
 	}
 
 	pos += magicNumberSize
+	m.txid = binary.LittleEndian.Uint64(buffer[pos:])
+
+	pos += pageNumberSize
 	m.rootPageNumber = binary.LittleEndian.Uint64(buffer[pos:])
 
 	pos += pageNumberSize
 	m.freelistPageNumber = binary.LittleEndian.Uint64(buffer[pos:])
+
+	// the fields below were appended to the legacy format and are therefore zero in legacy files
+	pos += pageNumberSize
+	m.version = binary.LittleEndian.Uint32(buffer[pos:])
+
+	if m.version == 0 {
+		m.version = legacyFormatVersion
+	}
+
+	pos += int32Offset
+	m.pageSize = binary.LittleEndian.Uint32(buffer[pos:])
+
+	pos += int32Offset
+	m.flags = binary.LittleEndian.Uint32(buffer[pos:])
+
+	return nil
+}
+
+// checkFormat checks if the file format described by the meta is supported. Files of an older format version have to
+// be migrated with Upgrade first.
+func (m *meta) checkFormat() error {
+	if m.version < formatVersion {
+		return fmt.Errorf("%w: version %d has to be upgraded to %d", ErrUnsupportedVersion, m.version, formatVersion)
+	}
+
+	if m.version > formatVersion {
+		return fmt.Errorf("%w: version %d is newer than %d", ErrUnsupportedVersion, m.version, formatVersion)
+	}
+
+	if m.flags&^supportedFeatureFlags != 0 {
+		return fmt.Errorf("%w: unknown feature flags %b", ErrUnsupportedVersion, m.flags&^supportedFeatureFlags)
+	}
+
+	return nil
+}
+
+// isValidMeta checks if given byte array holds a meta.
+func isValidMeta(buffer []byte) bool {
+	return len(buffer) >= metaSize && binary.LittleEndian.Uint32(buffer) == magicNumber
 }
@@ -4,14 +4,24 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 const (
 	byteOffset     = 1
 	int16Offset    = 2
+	int32Offset    = 4
 	nodeHeaderSize = 3
+	// itemFlagOverflow marks an item whose value is stored on overflow pages.
+	itemFlagOverflow byte = 1 << 0
+	// itemFlagCollection marks an item whose value is a serialized sub-collection.
+	itemFlagCollection byte = 1 << 1
+	// maxValueSize defines the biggest value that can be stored.
+	maxValueSize = 1<<31 - 1
 )
 
+var errMalformedNode = fmt.Errorf("%w: node exceeds its page", ErrCorrupt)
+
 // NewItem creates a new item object with given key, value pairs.
 func NewItem(key []byte, value []byte) *Item {
 	return &Item{
@@ -20,10 +30,33 @@ func NewItem(key []byte, value []byte) *Item {
 	}
 }
 
-// Item is a key, value pair in B-Tree node.
+// Item is a key, value pair in B-Tree node. Large values are stored on consecutive overflow pages, which are
+// assigned on commit and shared by all copies of the item, since items are never modified.
 type Item struct {
-	key   []byte
-	value []byte
+	key          []byte
+	value        []byte
+	overflowPage uint64
+	// collection is set if the item holds a sub-collection instead of a value.
+	collection bool
+}
+
+// Key returns the key of the item.
+func (i Item) Key() []byte {
+	return i.key
+}
+
+// Value returns the value of the item. Nil is returned if the item holds a sub-collection.
+func (i Item) Value() []byte {
+	if i.collection {
+		return nil
+	}
+
+	return i.value
+}
+
+// IsCollection returns if the item holds a sub-collection, which is opened by Collection.GetCollection.
+func (i Item) IsCollection() bool {
+	return i.collection
 }
 
 // size returns the size of the items in bytes.
@@ -31,6 +64,39 @@ func (i Item) size() int {
 	return len(i.key) + len(i.value)
 }
 
+// flags returns the flags stored in front of the serialized item.
+func (i Item) flags() byte {
+	var flags byte
+
+	if i.overflowPage != 0 {
+		flags |= itemFlagOverflow
+	}
+
+	if i.collection {
+		flags |= itemFlagCollection
+	}
+
+	return flags
+}
+
+// elementSize returns the size of the serialized item, either with its value or a reference to the overflow pages.
+func (i Item) elementSize() int {
+	size := byteOffset + uvarintSize(len(i.key)) + len(i.key) + uvarintSize(len(i.value))
+
+	if i.overflowPage != 0 {
+		return size + pageNumberSize
+	}
+
+	return size + len(i.value)
+}
+
+// uvarintSize returns the size of given value encoded as varint.
+func uvarintSize(value int) int {
+	buffer := make([]byte, binary.MaxVarintLen64)
+
+	return binary.PutUvarint(buffer, uint64(value))
+}
+
 // NewEmptyNode creates a new node object.
 func newEmptyNode() *node {
 	return &node{}
@@ -50,10 +116,16 @@ func (n *node) isLeaf() bool {
 	return len(n.childNodes) == 0
 }
 
-// serialize serializes the node by converting the data to a slotted page format.
+//nolint:godot
+// serialize serializes the node by converting the data to a slotted page format. The slots (child page numbers and
+// offsets) grow from the left, the elements they point to grow from the right. An element is laid out as:
+/*
+ * | flags | key length (varint) | key | value length (varint) | value or first overflow page |
+ */
+// The value is stored on overflow pages if the item overflows, the overflow pages have to be assigned beforehand.
 func (n *node) serialize(buffer []byte) []byte {
 	leftPos := 0
-	rightPos := len(buffer) - 1
+	rightPos := len(buffer)
 	isLeaf := n.isLeaf()
 
 	buffer[leftPos] = byte(0)
@@ -75,24 +147,24 @@ func (n *node) serialize(buffer []byte) []byte {
 			leftPos += pageNumberSize
 		}
 
-		keyCount := len(item.key)
-		valueCount := len(item.value)
-
-		offset := rightPos - keyCount - valueCount - int16Offset
-		binary.LittleEndian.PutUint16(buffer[leftPos:], uint16(offset))
+		rightPos -= item.elementSize()
+		binary.LittleEndian.PutUint16(buffer[leftPos:], uint16(rightPos))
 		leftPos += int16Offset
 
-		rightPos -= valueCount
-		copy(buffer[rightPos:], item.value)
+		pos := rightPos
 
-		rightPos -= byteOffset
-		buffer[rightPos] = byte(valueCount)
+		buffer[pos] = item.flags()
+		pos += byteOffset
 
-		rightPos -= byteOffset
-		copy(buffer[rightPos:], item.key)
+		pos += binary.PutUvarint(buffer[pos:], uint64(len(item.key)))
+		pos += copy(buffer[pos:], item.key)
+		pos += binary.PutUvarint(buffer[pos:], uint64(len(item.value)))
 
-		rightPos -= byteOffset
-		buffer[rightPos] = byte(keyCount)
+		if item.overflowPage != 0 {
+			binary.LittleEndian.PutUint64(buffer[pos:], item.overflowPage)
+		} else {
+			copy(buffer[pos:], item.value)
+		}
 	}
 
 	if !isLeaf {
@@ -103,8 +175,14 @@ func (n *node) serialize(buffer []byte) []byte {
 	return buffer
 }
 
-// deserialize deserializes a byte array to node by converting the data from a slotted page format.
-func (n *node) deserialize(buffer []byte) {
+// deserialize deserializes a byte array to node by converting the data from a slotted page format. Every length and
+// offset is checked against the buffer, so a damaged page results in ErrCorrupt instead of a panic. Values stored on
+// overflow pages are read with given function.
+func (n *node) deserialize(buffer []byte, readOverflow func(number uint64, size int) ([]byte, error)) error {
+	if len(buffer) < nodeHeaderSize || buffer[0] > 1 {
+		return errMalformedNode
+	}
+
 	leftPos := 1
 	isLeaf := buffer[0]
 
@@ -113,32 +191,102 @@ func (n *node) deserialize(buffer []byte) {
 
 	for i := 0; i < itemsCount; i++ {
 		if isLeaf == 0 { // False
+			if leftPos+pageNumberSize > len(buffer) {
+				return errMalformedNode
+			}
+
 			pageNum := binary.LittleEndian.Uint64(buffer[leftPos:])
 			leftPos += pageNumberSize
 
 			n.childNodes = append(n.childNodes, pageNum)
 		}
 
-		offset := binary.LittleEndian.Uint16(buffer[leftPos:])
-		leftPos += int16Offset
-
-		keyCount := uint16(buffer[int(offset)])
-		offset += byteOffset
+		if leftPos+int16Offset > len(buffer) {
+			return errMalformedNode
+		}
 
-		key := buffer[offset : offset+keyCount]
-		offset += keyCount
+		offset := int(binary.LittleEndian.Uint16(buffer[leftPos:]))
+		leftPos += int16Offset
 
-		valueCount := uint16(buffer[int(offset)])
-		offset += byteOffset
+		item, err := deserializeElement(buffer, offset, readOverflow)
+		if err != nil {
+			return err
+		}
 
-		value := buffer[offset : offset+valueCount]
-		n.items = append(n.items, NewItem(key, value))
+		n.items = append(n.items, item)
 	}
 
 	if isLeaf == 0 {
+		if leftPos+pageNumberSize > len(buffer) {
+			return errMalformedNode
+		}
+
 		pageNum := binary.LittleEndian.Uint64(buffer[leftPos:])
 		n.childNodes = append(n.childNodes, pageNum)
 	}
+
+	return nil
+}
+
+// deserializeElement deserializes the element at given offset to an item.
+func deserializeElement(
+	buffer []byte, offset int, readOverflow func(number uint64, size int) ([]byte, error),
+) (*Item, error) {
+	if offset >= len(buffer) {
+		return nil, errMalformedNode
+	}
+
+	flags := buffer[offset]
+	offset += byteOffset
+
+	keyCount, read := binary.Uvarint(buffer[offset:])
+	if read <= 0 || keyCount > uint64(len(buffer)) {
+		return nil, errMalformedNode
+	}
+
+	offset += read
+
+	if offset+int(keyCount) > len(buffer) {
+		return nil, errMalformedNode
+	}
+
+	key := buffer[offset : offset+int(keyCount)]
+	offset += int(keyCount)
+
+	valueCount, read := binary.Uvarint(buffer[offset:])
+	if read <= 0 || valueCount > maxValueSize {
+		return nil, errMalformedNode
+	}
+
+	offset += read
+
+	if flags&itemFlagOverflow == 0 {
+		if offset+int(valueCount) > len(buffer) {
+			return nil, errMalformedNode
+		}
+
+		item := NewItem(key, buffer[offset:offset+int(valueCount)])
+		item.collection = flags&itemFlagCollection != 0
+
+		return item, nil
+	}
+
+	if offset+pageNumberSize > len(buffer) {
+		return nil, errMalformedNode
+	}
+
+	item := NewItem(key, nil)
+	item.collection = flags&itemFlagCollection != 0
+	item.overflowPage = binary.LittleEndian.Uint64(buffer[offset:])
+
+	value, err := readOverflow(item.overflowPage, int(valueCount))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overflow pages: %w", err)
+	}
+
+	item.value = value
+
+	return item, nil
 }
 
 // findKey searches for a key inside the tree. Once the key is found, the parent node and the correct index are returned
@@ -156,27 +304,12 @@ func (n *node) findKey(key []byte, exact bool) (int, *node, []int, error) {
 }
 
 // findKeyRecursively recursively search for key as follows:
-// iterates all the items and finds the key. If the key is found, then the item is returned. If the key
+// searches the items of the node for the key. If the key is found, then the item is returned. If the key
 // isn't found then return the index where it should have been (the first index that key is greater than it's previous).
 func findKeyRecursively(
 	node *node, key []byte, exact bool, ancestorsIndexes *[]int,
 ) (int, *node, error) {
-	wasFound := false
-	index := len(node.items)
-
-	for searchIndex, existingItem := range node.items {
-		res := bytes.Compare(existingItem.key, key)
-		if res == 0 {
-			wasFound = true
-			index = searchIndex
-
-			break
-		} else if res == 1 {
-			index = searchIndex
-
-			break
-		}
-	}
+	index, wasFound := node.search(key)
 
 	if wasFound {
 		return index, node, nil
@@ -198,11 +331,41 @@ func findKeyRecursively(
 	return findKeyRecursively(nextChild, key, exact, ancestorsIndexes)
 }
 
+// search performs a binary search for given key within the items of the node. It returns the index of the item with
+// the key if it was found and the index of the first item with a greater key otherwise.
+func (n *node) search(key []byte) (int, bool) {
+	index := sort.Search(len(n.items), func(i int) bool {
+		return bytes.Compare(n.items[i].key, key) >= 0
+	})
+
+	return index, index < len(n.items) && bytes.Equal(n.items[index].key, key)
+}
+
 // nodeSize returns the node's size in bytes.
 func (n *node) size() int {
 	size := nodeHeaderSize
 	for _, item := range n.items {
-		size += item.size() + pageNumberSize
+		size += n.elementSize(item)
+	}
+
+	if !n.isLeaf() {
+		size += pageNumberSize
+	}
+
+	return size
+}
+
+// elementSize returns the serialized size of given item including its offset and child page number. Items that
+// overflow are counted with the reference to their overflow pages, even if those are not assigned yet.
+func (n *node) elementSize(item *Item) int {
+	size := item.elementSize() + int16Offset
+
+	if item.overflowPage == 0 && n.dal != nil && n.dal.overflows(item) {
+		size += pageNumberSize - len(item.value)
+	}
+
+	if !n.isLeaf() {
+		size += pageNumberSize
 	}
 
 	return size
@@ -234,12 +397,15 @@ func (n *node) split(nodeToSplit *node, nodeToSplitIndex int) {
 	}
 
 	middleItem := nodeToSplit.items[splitIndex]
+	// the new node gets its own copies, since both nodes append to their slices later on
+	newItems := append([]*Item{}, nodeToSplit.items[splitIndex+1:]...)
 	var newNode *node //nolint:wsl
 
 	if nodeToSplit.isLeaf() {
-		newNode = n.tx.writeNode(n.tx.newNode(nodeToSplit.items[splitIndex+1:], []uint64{}))
+		newNode = n.tx.writeNode(n.tx.newNode(newItems, []uint64{}))
 	} else {
-		newNode = n.tx.writeNode(n.tx.newNode(nodeToSplit.items[splitIndex+1:], nodeToSplit.childNodes[splitIndex+1:]))
+		newChildNodes := append([]uint64{}, nodeToSplit.childNodes[splitIndex+1:]...)
+		newNode = n.tx.writeNode(n.tx.newNode(newItems, newChildNodes))
 		nodeToSplit.childNodes = nodeToSplit.childNodes[:splitIndex+1]
 	}
 
@@ -277,7 +443,7 @@ func (n *node) removeItemFromInternal(index int) ([]int, error) {
 	}
 
 	for !aNode.isLeaf() {
-		traversingIndex := len(n.childNodes) - 1
+		traversingIndex := len(aNode.childNodes) - 1
 
 		aNode, err = aNode.tx.getNode(aNode.childNodes[traversingIndex])
 		if err != nil {
@@ -374,19 +540,22 @@ func (n *node) merge(bNode *node, bNodeIndex int) error {
 		aNode.childNodes = append(aNode.childNodes, bNode.childNodes...)
 	}
 
-	if err = n.dal.writeNodes(aNode, n); err != nil {
-		return fmt.Errorf("failed to write node: %w", err)
+	// both nodes can be about half full, so the merged node is split again if it exceeds the fill limit
+	if aNode.isOverPopulated() {
+		n.split(aNode, bNodeIndex-1)
 	}
 
+	n.tx.writeNodes(aNode, n)
 	n.tx.deleteNode(bNode)
 
 	return nil
 }
 
 // rebalanceRemove rebalance the tree after a remove operation. This can be either by rotating to the right, to the
-// left or by merging. First, the sibling nodes are checked to see if they have enough items for rebalancing
-// (>= minItems+1). If they don't have enough items, then merging with one of the sibling nodes occurs. This may leave
-// the parent unbalanced by having too little items so rebalancing has to be checked for all the ancestors.
+// left or by merging. First, the sibling nodes are checked to see if they can spare an item while staying half full
+// and if the unbalanced node has room for the separator it receives. Otherwise, merging with one of the sibling nodes
+// occurs. This may leave the parent unbalanced by having too little items, or over populated since the separator
+// from a sibling can be bigger than the one it replaces. So the ancestors have to be checked for both.
 func (n *node) rebalanceRemove(unbalancedNode *node, unbalancedNodeIndex int) error {
 	pNode := n
 
@@ -396,7 +565,9 @@ func (n *node) rebalanceRemove(unbalancedNode *node, unbalancedNodeIndex int) er
 			return fmt.Errorf("failed to get node: %w", err)
 		}
 
-		if n.dal.getSplitIndex(leftNode) != -1 {
+		separator := pNode.items[unbalancedNodeIndex-1]
+
+		if n.dal.canSpareItem(leftNode, len(leftNode.items)-1) && n.dal.canHold(unbalancedNode, separator) {
 			rotateRight(leftNode, pNode, unbalancedNode, unbalancedNodeIndex)
 			n.tx.writeNodes(leftNode, pNode, unbalancedNode)
 
@@ -410,7 +581,9 @@ func (n *node) rebalanceRemove(unbalancedNode *node, unbalancedNodeIndex int) er
 			return fmt.Errorf("failed to get node: %w", err)
 		}
 
-		if n.dal.getSplitIndex(rightNode) != -1 {
+		separator := pNode.items[unbalancedNodeIndex]
+
+		if n.dal.canSpareItem(rightNode, 0) && n.dal.canHold(unbalancedNode, separator) {
 			rotateLeft(unbalancedNode, pNode, rightNode, unbalancedNodeIndex)
 			n.tx.writeNodes(unbalancedNode, pNode, rightNode)
 
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// SyncMode defines when committed pages are flushed from the operating system cache to disk.
+type SyncMode int
+
+const (
+	// SyncAlways flushes the file on every commit, so a successful commit survives a power loss.
+	SyncAlways SyncMode = iota
+	// SyncInterval flushes the file in the background every Options.SyncInterval. Commits survive a crash of the
+	// process, but a power loss can corrupt the database: the operating system may write the meta page of a commit to
+	// disk before the pages it refers to, which leaves a valid meta page pointing to pages that were never written.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system. Commits survive a crash of the process, but a power loss can
+	// corrupt the database like with SyncInterval.
+	SyncNever
+)
+
+const (
+	defaultSyncInterval  = 100 * time.Millisecond
+	defaultMaxBatchSize  = 1000
+	defaultMaxBatchDelay = 10 * time.Millisecond
+	// minPageSize defines the smallest supported page size.
+	minPageSize = 512
+	// maxPageSize defines the biggest supported page size, since offsets within a page are stored as 16 bit integers.
+	maxPageSize = 64 * 1024
+)
+
+var (
+	ErrInvalidSyncMode     = errors.New("invalid sync mode")
+	ErrInvalidSyncInterval = errors.New("sync interval must be greater than zero")
+	ErrInvalidPageSize     = errors.New("page size must be a power of two between 512 and 65536 bytes")
+)
+
+// DefaultOptions returns the options used when opening a database without options.
+func DefaultOptions() *Options {
+	return &Options{
+		SyncMode:      SyncAlways,
+		SyncInterval:  defaultSyncInterval,
+		PageSize:      defaultPageSize(),
+		MaxBatchSize:  defaultMaxBatchSize,
+		MaxBatchDelay: defaultMaxBatchDelay,
+	}
+}
+
+// Options defines the settings used when opening a database.
+type Options struct {
+	// SyncMode defines the durability of commits.
+	SyncMode SyncMode
+	// SyncInterval defines the interval of background flushes if SyncMode is SyncInterval.
+	SyncInterval time.Duration
+	// PageSize defines the page size of a new database file. Zero selects the page size of the host. Existing files
+	// keep the page size they were created with.
+	PageSize int
+	// MaxBatchSize defines the number of DB.Batch calls that are run in a single transaction at most. Zero selects
+	// the default.
+	MaxBatchSize int
+	// MaxBatchDelay defines how long DB.Batch waits for further calls before the batch is run. Zero selects the
+	// default.
+	MaxBatchDelay time.Duration
+}
+
+// maxBatchSize returns the maximal batch size or the default if none is set.
+func (o *Options) maxBatchSize() int {
+	if o.MaxBatchSize <= 0 {
+		return defaultMaxBatchSize
+	}
+
+	return o.MaxBatchSize
+}
+
+// maxBatchDelay returns the maximal batch delay or the default if none is set.
+func (o *Options) maxBatchDelay() time.Duration {
+	if o.MaxBatchDelay <= 0 {
+		return defaultMaxBatchDelay
+	}
+
+	return o.MaxBatchDelay
+}
+
+// TxOptions defines the settings of a transaction created with DB.BeginTx.
+type TxOptions struct {
+	// Writable creates a write transaction instead of a read transaction.
+	Writable bool
+}
+
+// defaultPageSize returns the page size of the host limited to the supported page sizes.
+func defaultPageSize() int {
+	pageSize := os.Getpagesize()
+
+	switch {
+	case pageSize < minPageSize:
+		return minPageSize
+	case pageSize > maxPageSize:
+		return maxPageSize
+	default:
+		return pageSize
+	}
+}
+
+// isValidPageSize checks if given page size is a supported power of two.
+func isValidPageSize(pageSize int) bool {
+	return pageSize >= minPageSize && pageSize <= maxPageSize && pageSize&(pageSize-1) == 0
+}
+
+// validate checks if the options are valid.
+func (o *Options) validate() error {
+	if o.PageSize != 0 && !isValidPageSize(o.PageSize) {
+		return ErrInvalidPageSize
+	}
+
+	switch o.SyncMode {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if o.SyncInterval <= 0 {
+			return ErrInvalidSyncInterval
+		}
+	default:
+		return ErrInvalidSyncMode
+	}
+
+	return nil
+}
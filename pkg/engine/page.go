@@ -1,5 +1,33 @@
 package engine
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// pageHeaderSize defines the size of the header in front of every page, which holds the checksum of the page.
+const pageHeaderSize = checksumSize
+
+// ErrCorrupt is returned if the database file contains data that can not be deserialized.
+var ErrCorrupt = errors.New("database file is corrupt")
+
+// ErrCorruptPage is returned if the content of a page does not match its checksum. It matches ErrCorrupt.
+type ErrCorruptPage struct { //nolint:errname
+	PageNumber uint64
+}
+
+// Error returns the error message.
+func (e ErrCorruptPage) Error() string {
+	return fmt.Sprintf("page %d is corrupt", e.PageNumber)
+}
+
+// Unwrap returns ErrCorrupt, so errors.Is matches it.
+func (e ErrCorruptPage) Unwrap() error {
+	return ErrCorrupt
+}
+
 // newPage creates a new page object.
 func newPage(pageSize uint) *page {
 	return &page{
@@ -12,3 +40,33 @@ type page struct {
 	data   []byte
 	number uint64
 }
+
+// body returns the content of the page behind the header.
+func (p *page) body() []byte {
+	return p.data[pageHeaderSize:]
+}
+
+// checksum calculates the CRC32C checksum of the page content. The page number is included, so a page that is
+// written to the wrong position is detected as well.
+func (p *page) checksum() uint32 {
+	table := crc32.MakeTable(crc32.Castagnoli)
+	number := make([]byte, pageNumberSize)
+
+	binary.LittleEndian.PutUint64(number, p.number)
+
+	return crc32.Update(crc32.Checksum(number, table), table, p.body())
+}
+
+// seal writes the checksum into the page header.
+func (p *page) seal() {
+	binary.LittleEndian.PutUint32(p.data, p.checksum())
+}
+
+// verify checks if the page content matches the checksum in the page header.
+func (p *page) verify() error {
+	if binary.LittleEndian.Uint32(p.data) != p.checksum() {
+		return ErrCorruptPage{PageNumber: p.number}
+	}
+
+	return nil
+}
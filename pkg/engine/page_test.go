@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCorruptPage flips a byte of a leaf, which has to be reported as corrupt page by Find.
+func TestCorruptPage(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "db")
+
+	db, err := Open(path, &Options{PageSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	putKeys(t, db, 500, 10)
+
+	var (
+		leaf uint64
+		key  []byte
+	)
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		// the first leaf holds the smallest keys
+		treeNode, err := tx.getNode(collection.root)
+		for err == nil && !treeNode.isLeaf() {
+			treeNode, err = tx.getNode(treeNode.childNodes[0])
+		}
+
+		if err == nil {
+			leaf, key = treeNode.pageNumber, treeNode.items[0].key
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to find leaf: %v", err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	content := readFile(t, path)
+	content[(leaf+1)*512-1] ^= 0xff
+
+	if err = os.WriteFile(path, content, fileMode); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	db, err = Open(path, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		_, err = collection.Find(key)
+
+		return err
+	})
+
+	var corruptPage ErrCorruptPage
+
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &corruptPage) {
+		t.Fatalf("expected a corrupt page, got %v", err)
+	}
+
+	if corruptPage.PageNumber != leaf {
+		t.Fatalf("page %d was reported as corrupt instead of %d", corruptPage.PageNumber, leaf)
+	}
+}
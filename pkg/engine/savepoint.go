@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrSavepointReleased = errors.New("savepoint is released or was rolled back")
+
+// Savepoint marks a state of a write transaction, which can be restored without rolling back the whole transaction.
+// Savepoints can be nested: rolling back to a savepoint or releasing it invalidates the savepoints created after it.
+type Savepoint struct {
+	tx                   *Transaction
+	freelist             *freelist
+	dirtyNodes           map[uint64]*node
+	collections          map[string]*collectionState
+	rootCollection       *collectionState
+	pagesToDelete        int
+	allocatedPageNumbers int
+	changes              int
+	commitHandlers       int
+	rollbackHandlers     int
+}
+
+// collectionState holds a cached collection together with the state it had when the savepoint was created.
+type collectionState struct {
+	collection *Collection
+	state      Collection
+}
+
+// Savepoint creates a savepoint of the current state of the transaction.
+func (t *Transaction) Savepoint() (*Savepoint, error) {
+	if err := t.checkWrite(); err != nil {
+		return nil, err
+	}
+
+	savepoint := &Savepoint{
+		tx:                   t,
+		freelist:             t.db.freelist.snapshot(),
+		dirtyNodes:           copyNodes(t.dirtyNodes),
+		collections:          make(map[string]*collectionState, len(t.collections)),
+		rootCollection:       &collectionState{collection: t.rootCollection, state: *t.rootCollection},
+		pagesToDelete:        len(t.pagesToDelete),
+		allocatedPageNumbers: len(t.allocatedPageNumbers),
+		changes:              len(t.changes),
+		commitHandlers:       len(t.commitHandlers),
+		rollbackHandlers:     len(t.rollbackHandlers),
+	}
+
+	for name, collection := range t.collections {
+		savepoint.collections[name] = &collectionState{collection: collection, state: *collection}
+	}
+
+	t.savepoints = append(t.savepoints, savepoint)
+
+	return savepoint, nil
+}
+
+// Rollback restores the state of the transaction when the savepoint was created. The dirty nodes and collections are
+// restored and the pages allocated since then are returned to the freelist. Collections opened since then are read
+// again, so they can still be used. Changes and commit handlers recorded since then are dropped, rollback handlers
+// registered since then are called. The savepoint stays valid, so it can be rolled back to again. Collections created
+// after the savepoint must not be used anymore.
+func (s *Savepoint) Rollback() error {
+	index, err := s.index()
+	if err != nil {
+		return err
+	}
+
+	t := s.tx
+	opened := make([]*Collection, 0)
+
+	for path, collection := range t.collections {
+		if _, ok := s.collections[path]; !ok {
+			opened = append(opened, collection)
+		}
+	}
+
+	t.savepoints = t.savepoints[:index+1]
+	t.dirtyNodes = copyNodes(s.dirtyNodes)
+	t.collections = make(map[string]*Collection, len(s.collections)+len(opened))
+
+	for name, saved := range s.collections {
+		*saved.collection = saved.state
+		t.collections[name] = saved.collection
+	}
+
+	*s.rootCollection.collection = s.rootCollection.state
+
+	// parents are read again before the collections within them
+	sort.Slice(opened, func(i, j int) bool {
+		return opened[i].depth() < opened[j].depth()
+	})
+
+	for _, collection := range opened {
+		if err = s.reopen(collection); err != nil {
+			return err
+		}
+	}
+
+	t.db.freelist.restore(s.freelist)
+	t.allocatedPageNumbers = t.allocatedPageNumbers[:s.allocatedPageNumbers]
+	t.pagesToDelete = t.pagesToDelete[:s.pagesToDelete]
+	t.changes = t.changes[:s.changes]
+	t.commitHandlers = t.commitHandlers[:s.commitHandlers]
+	rollbackHandlers := t.rollbackHandlers[s.rollbackHandlers:]
+	t.rollbackHandlers = t.rollbackHandlers[:s.rollbackHandlers]
+
+	for _, handler := range rollbackHandlers {
+		handler()
+	}
+
+	return nil
+}
+
+// reopen resets given collection, which was opened after the savepoint, to the state stored in its restored parent.
+// The collection is not cached again if it does not exist anymore, since it or its parent was created after the
+// savepoint.
+func (s *Savepoint) reopen(collection *Collection) error {
+	parent := collection.parent
+	if parent != s.tx.rootCollection && s.tx.collections[parent.path] != parent {
+		return nil
+	}
+
+	item, err := parent.find(collection.name)
+	if err != nil {
+		return err
+	}
+
+	if item == nil || item.collection != parent.flagsCollections() {
+		return nil
+	}
+
+	state := Collection{dal: collection.dal, tx: collection.tx, parent: parent, path: collection.path}
+
+	if err = state.deserialize(item); err != nil {
+		return fmt.Errorf("failed to deserialize collection: %w", err)
+	}
+
+	*collection = state
+	s.tx.collections[collection.path] = collection
+
+	return nil
+}
+
+// Release discards the savepoint and the savepoints created after it, the changes made since then are kept.
+func (s *Savepoint) Release() error {
+	index, err := s.index()
+	if err != nil {
+		return err
+	}
+
+	s.tx.savepoints = s.tx.savepoints[:index]
+
+	return nil
+}
+
+// index returns the position of the savepoint on the stack of its transaction.
+func (s *Savepoint) index() (int, error) {
+	if s.tx.closed {
+		return -1, ErrTxClosed
+	}
+
+	for index, savepoint := range s.tx.savepoints {
+		if savepoint == s {
+			return index, nil
+		}
+	}
+
+	return -1, ErrSavepointReleased
+}
+
+// copyNodes copies given nodes, so later changes of the nodes don't affect the copies. Items are shared, since they
+// are replaced instead of modified.
+func copyNodes(nodes map[uint64]*node) map[uint64]*node {
+	copies := make(map[uint64]*node, len(nodes))
+
+	for pageNumber, original := range nodes {
+		nodeCopy := *original
+		nodeCopy.items = append([]*Item{}, original.items...)
+		nodeCopy.childNodes = append([]uint64{}, original.childNodes...)
+		copies[pageNumber] = &nodeCopy
+	}
+
+	return copies
+}
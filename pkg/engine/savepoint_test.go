@@ -0,0 +1,103 @@
+package engine
+
+import "testing"
+
+// TestSavepointRollbackOpenedCollection uses collections that were opened after the savepoint once it was rolled back.
+// Their changes were lost on commit, since the rollback dropped them from the transaction.
+func TestSavepointRollbackOpenedCollection(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.CreateCollection([]byte("b"))
+		if err == nil {
+			_, err = collection.CreateCollection([]byte("nested"))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create collections: %v", err)
+	}
+
+	err = db.Update(func(tx *Transaction) error {
+		savepoint, err := tx.Savepoint()
+		if err != nil {
+			return err
+		}
+
+		collection, err := tx.GetCollection([]byte("b"))
+		if err != nil {
+			return err
+		}
+
+		nested, err := collection.GetCollection([]byte("nested"))
+		if err != nil {
+			return err
+		}
+
+		created, err := collection.CreateCollection([]byte("created"))
+		if err != nil {
+			return err
+		}
+
+		for _, target := range []*Collection{collection, nested, created} {
+			if err = target.Put([]byte("y"), []byte("rolled back")); err != nil {
+				return err
+			}
+		}
+
+		if err = savepoint.Rollback(); err != nil {
+			return err
+		}
+
+		for _, target := range []*Collection{collection, nested} {
+			if item, findErr := target.Find([]byte("y")); findErr != nil || item != nil {
+				t.Fatalf("key put after the savepoint was found after the rollback: %v", findErr)
+			}
+
+			if err = target.Put([]byte("z"), []byte("kept")); err != nil {
+				return err
+			}
+		}
+
+		if created, err = collection.GetCollection([]byte("created")); err != nil || created != nil {
+			t.Fatalf("collection created after the savepoint was found after the rollback: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("b"))
+		if err != nil {
+			return err
+		}
+
+		nested, err := collection.GetCollection([]byte("nested"))
+		if err != nil {
+			return err
+		}
+
+		// the scan of the collection includes the nested collection, so only the nested collection is compared
+		checkContent(t, nested, map[string][]byte{"z": []byte("kept")})
+
+		if count, countErr := collection.Len(); countErr != nil || count != 1 {
+			t.Fatalf("collection has %d keys instead of 1: %v", count, countErr)
+		}
+
+		item, err := collection.Find([]byte("z"))
+		if err != nil || item == nil || string(item.Value()) != "kept" {
+			t.Fatalf("key put after the rollback was not committed: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package engine
+
+import "bytes"
+
+// ScanFunc is called for every item of a scan. Returning false stops the scan. Keys that hold a sub-collection are
+// passed as well, Item.IsCollection reports them.
+type ScanFunc func(item *Item) bool
+
+// ScanOptions defines the range and order of a scan.
+type ScanOptions struct {
+	// Start defines the first key of the range, nil starts at the first item.
+	Start []byte
+	// End defines the key behind the range, which is not included. Nil ends at the last item.
+	End []byte
+	// Limit defines the maximal number of items passed to the ScanFunc, zero scans all items.
+	Limit int
+	// Reverse scans the range in descending key order.
+	Reverse bool
+}
+
+// Scan calls given function for the items with keys from start (inclusive) to end (exclusive) in ascending order.
+func (c *Collection) Scan(start []byte, end []byte, fn ScanFunc) error {
+	return c.ScanWithOptions(&ScanOptions{Start: start, End: end}, fn)
+}
+
+// ScanReverse calls given function for the items with keys from start (inclusive) to end (exclusive) in descending
+// order.
+func (c *Collection) ScanReverse(start []byte, end []byte, fn ScanFunc) error {
+	return c.ScanWithOptions(&ScanOptions{Start: start, End: end, Reverse: true}, fn)
+}
+
+// ScanPrefix calls given function for the items with keys starting with given prefix in ascending order.
+func (c *Collection) ScanPrefix(prefix []byte, fn ScanFunc) error {
+	return c.ScanWithOptions(&ScanOptions{Start: prefix, End: prefixEnd(prefix)}, fn)
+}
+
+// ScanPrefixReverse calls given function for the items with keys starting with given prefix in descending order.
+func (c *Collection) ScanPrefixReverse(prefix []byte, fn ScanFunc) error {
+	return c.ScanWithOptions(&ScanOptions{Start: prefix, End: prefixEnd(prefix), Reverse: true}, fn)
+}
+
+// ScanWithOptions calls given function for the items in the range of given options until the range or the limit is
+// exhausted or the function returns false. The scan is aborted with the error of the context of the transaction once
+// the context is done.
+func (c *Collection) ScanWithOptions(options *ScanOptions, fn ScanFunc) error {
+	var (
+		cursor = c.Cursor()
+		item   *Item
+		err    error
+	)
+
+	if options.Reverse {
+		item, err = c.seekLast(cursor, options.End)
+	} else {
+		item, err = cursor.Seek(options.Start)
+	}
+
+	for count := 0; err == nil && item != nil; count++ {
+		if options.Limit > 0 && count >= options.Limit {
+			return nil
+		}
+
+		if err = c.tx.checkOpen(); err != nil {
+			return err
+		}
+
+		if !options.isInRange(item.key) || !fn(item) {
+			return nil
+		}
+
+		if options.Reverse {
+			item, err = cursor.Prev()
+		} else {
+			item, err = cursor.Next()
+		}
+	}
+
+	return err
+}
+
+// seekLast moves given cursor to the last item with a key smaller than given end and returns it. If end is nil, the
+// cursor is moved to the last item.
+func (c *Collection) seekLast(cursor *Cursor, end []byte) (*Item, error) {
+	if end == nil {
+		return cursor.Last()
+	}
+
+	item, err := cursor.Seek(end)
+	if err != nil {
+		return nil, err
+	}
+
+	if item == nil {
+		return cursor.Last()
+	}
+
+	return cursor.Prev()
+}
+
+// isInRange returns if given key is within the range of the options.
+func (o *ScanOptions) isInRange(key []byte) bool {
+	if o.Start != nil && bytes.Compare(key, o.Start) < 0 {
+		return false
+	}
+
+	return o.End == nil || bytes.Compare(key, o.End) < 0
+}
+
+// prefixEnd returns the smallest key that is greater than all keys with given prefix. Nil is returned if there is no
+// such key, since the prefix consists of 0xFF bytes only.
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF { //nolint:gomnd
+			end[i]++
+
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
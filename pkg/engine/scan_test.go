@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestScanWithOptions scans ranges of a collection in both orders and with limits.
+func TestScanWithOptions(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 1000, 10)
+
+	tests := []struct {
+		name     string
+		options  ScanOptions
+		expected []string
+	}{
+		{name: "range", options: ScanOptions{Start: testKey(100), End: testKey(200)}, expected: testKeys(100, 199)},
+		{name: "reverse", options: ScanOptions{Reverse: true}, expected: testKeys(999, 0)},
+		{
+			name:     "reverse range",
+			options:  ScanOptions{Start: testKey(100), End: testKey(200), Reverse: true},
+			expected: testKeys(199, 100),
+		},
+		{
+			name:     "reverse end between keys",
+			options:  ScanOptions{End: append(testKey(500), 0), Reverse: true},
+			expected: testKeys(500, 0),
+		},
+		{
+			name:     "reverse prefix",
+			options:  ScanOptions{Start: []byte("key001"), End: prefixEnd([]byte("key001")), Reverse: true},
+			expected: testKeys(199, 100),
+		},
+		{name: "limit", options: ScanOptions{Start: testKey(500), Limit: 10}, expected: testKeys(500, 509)},
+		{
+			name:     "reverse limit",
+			options:  ScanOptions{End: testKey(500), Limit: 10, Reverse: true},
+			expected: testKeys(499, 490),
+		},
+		{name: "limit beyond range", options: ScanOptions{Start: testKey(995), Limit: 10}, expected: testKeys(995, 999)},
+		{name: "empty", options: ScanOptions{Start: testKey(200), End: testKey(100)}, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := db.View(func(tx *Transaction) error {
+				collection, err := tx.GetCollection([]byte("collection"))
+				if err != nil {
+					return err
+				}
+
+				scanned := make([]string, 0)
+
+				err = collection.ScanWithOptions(&test.options, func(item *Item) bool {
+					scanned = append(scanned, string(item.Key()))
+
+					return true
+				})
+				if err != nil {
+					return err
+				}
+
+				if fmt.Sprint(scanned) != fmt.Sprint(test.expected) {
+					t.Fatalf("the scan returned %d keys %v instead of %v", len(scanned), scanned, test.expected)
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+		})
+	}
+}
+
+// testKey returns the key with given index as stored by putKeys.
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+// testKeys returns the keys stored by putKeys from index from to index to, both inclusive, in the order of the indexes.
+func testKeys(from int, to int) []string {
+	step := 1
+	if from > to {
+		step = -1
+	}
+
+	keys := make([]string, 0)
+
+	for i := from; i != to+step; i += step {
+		keys = append(keys, string(testKey(i)))
+	}
+
+	return keys
+}
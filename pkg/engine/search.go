@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// slottedNode gives access to the keys and child nodes of a serialized node without deserializing its items, see
+// node.serialize for the layout.
+type slottedNode struct {
+	buffer []byte
+	count  int
+	isLeaf bool
+}
+
+// newSlottedNode creates a slotted node for given buffer and checks that its slots are within the buffer.
+func newSlottedNode(buffer []byte) (*slottedNode, error) {
+	if len(buffer) < nodeHeaderSize || buffer[0] > 1 {
+		return nil, errMalformedNode
+	}
+
+	slotted := &slottedNode{
+		buffer: buffer,
+		count:  int(binary.LittleEndian.Uint16(buffer[byteOffset:])),
+		isLeaf: buffer[0] == 1,
+	}
+
+	slotsEnd := nodeHeaderSize + slotted.count*slotted.slotSize()
+	if !slotted.isLeaf {
+		slotsEnd += pageNumberSize
+	}
+
+	if slotsEnd > len(buffer) {
+		return nil, errMalformedNode
+	}
+
+	return slotted, nil
+}
+
+// slotSize returns the size of a slot, which is the offset of an element preceded by a child page number for
+// internal nodes.
+func (s *slottedNode) slotSize() int {
+	if s.isLeaf {
+		return int16Offset
+	}
+
+	return pageNumberSize + int16Offset
+}
+
+// offset returns the offset of the element with given index.
+func (s *slottedNode) offset(index int) int {
+	position := nodeHeaderSize + index*s.slotSize()
+	if !s.isLeaf {
+		position += pageNumberSize
+	}
+
+	return int(binary.LittleEndian.Uint16(s.buffer[position:]))
+}
+
+// childNode returns the page number of the child node with given index, which is the child left of the element with
+// the same index.
+func (s *slottedNode) childNode(index int) uint64 {
+	return binary.LittleEndian.Uint64(s.buffer[nodeHeaderSize+index*s.slotSize():])
+}
+
+// key returns the key of the element with given index.
+func (s *slottedNode) key(index int) ([]byte, error) {
+	offset := s.offset(index) + byteOffset
+	if offset >= len(s.buffer) {
+		return nil, errMalformedNode
+	}
+
+	keyCount, read := binary.Uvarint(s.buffer[offset:])
+	if read <= 0 || keyCount > uint64(len(s.buffer)) {
+		return nil, errMalformedNode
+	}
+
+	offset += read
+
+	if offset+int(keyCount) > len(s.buffer) {
+		return nil, errMalformedNode
+	}
+
+	return s.buffer[offset : offset+int(keyCount)], nil
+}
+
+// search performs a binary search for given key, only the keys of the compared elements are read. It returns the
+// index of the element with the key if it was found and the index of the first element with a greater key otherwise.
+func (s *slottedNode) search(key []byte) (int, bool, error) {
+	var err error
+
+	index := sort.Search(s.count, func(i int) bool {
+		elementKey, keyErr := s.key(i)
+		if keyErr != nil {
+			err = keyErr
+
+			return true
+		}
+
+		return bytes.Compare(elementKey, key) >= 0
+	})
+	if err != nil || index == s.count {
+		return index, false, err
+	}
+
+	elementKey, err := s.key(index)
+
+	return index, bytes.Equal(elementKey, key), err
+}
+
+// findItem returns the item with given key in the tree with given root or nil if the key does not exist.
+func (t *Transaction) findItem(pageNumber uint64, key []byte) (*Item, error) {
+	for pageNumber != 0 {
+		item, childNode, err := t.searchNode(pageNumber, key)
+		if err != nil || item != nil {
+			return item, err
+		}
+
+		pageNumber = childNode
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// searchNode searches the node with given page number for given key. It returns the item with the key if it was found
+// and the page number of the child node that would contain the key otherwise, which is zero for a leaf. Nodes that are
+// not changed by the transaction are searched in their serialized form, so only the found item is deserialized.
+func (t *Transaction) searchNode(pageNumber uint64, key []byte) (*Item, uint64, error) {
+	if _, ok := t.dirtyNodes[pageNumber]; ok || t.db.version < overflowFormatVersion {
+		treeNode, err := t.getNode(pageNumber)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get node: %w", err)
+		}
+
+		index, found := treeNode.search(key)
+		if found {
+			return treeNode.items[index], 0, nil
+		} else if treeNode.isLeaf() {
+			return nil, 0, nil
+		}
+
+		return nil, treeNode.childNodes[index], nil
+	}
+
+	return t.searchPage(pageNumber, key)
+}
+
+// searchPage searches the serialized node on the page with given number like searchNode.
+func (t *Transaction) searchPage(pageNumber uint64, key []byte) (*Item, uint64, error) {
+	nodePage, err := t.db.readPage(pageNumber)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read node page from page %d: %w", pageNumber, err)
+	}
+
+	slotted, err := newSlottedNode(nodePage.body())
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search node page %d: %w", pageNumber, err)
+	}
+
+	index, found, err := slotted.search(key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search node page %d: %w", pageNumber, err)
+	}
+
+	if found {
+		item, err := deserializeElement(slotted.buffer, slotted.offset(index), t.db.readPages)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to deserialize item of node page %d: %w", pageNumber, err)
+		}
+
+		return item, 0, nil
+	} else if slotted.isLeaf {
+		return nil, 0, nil
+	}
+
+	return nil, slotted.childNode(index), nil
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkKeyCount defines the number of keys of the collection the benchmarks operate on.
+const benchmarkKeyCount = 1000000
+
+// openBenchmarkDB returns a database whose collection holds benchmarkKeyCount keys and the keys in ascending order.
+func openBenchmarkDB(b *testing.B) (*DB, [][]byte) {
+	b.Helper()
+
+	db, err := Open(filepath.Join(b.TempDir(), "db"), nil)
+	if err != nil {
+		b.Fatalf("failed to open database: %v", err)
+	}
+
+	b.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			b.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	keys := make([][]byte, benchmarkKeyCount)
+
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%07d", i))
+	}
+
+	err = db.Update(func(tx *Transaction) error {
+		_, createErr := tx.CreateCollection([]byte("collection"))
+
+		return createErr
+	})
+
+	// the keys are put in several transactions, which keeps the number of dirty nodes per commit small
+	for start := 0; err == nil && start < len(keys); start += benchmarkKeyCount / 10 {
+		batch := keys[start : start+benchmarkKeyCount/10]
+
+		err = db.Update(func(tx *Transaction) error {
+			collection, putErr := tx.GetCollection([]byte("collection"))
+
+			for i := 0; putErr == nil && i < len(batch); i++ {
+				putErr = collection.Put(batch[i], batch[i])
+			}
+
+			return putErr
+		})
+	}
+
+	if err != nil {
+		b.Fatalf("failed to put keys: %v", err)
+	}
+
+	return db, keys
+}
+
+func BenchmarkFind(b *testing.B) {
+	db, keys := openBenchmarkDB(b)
+	random := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	tx := db.ReadTransaction()
+	defer tx.Rollback()
+
+	collection, err := tx.GetCollection([]byte("collection"))
+	if err != nil {
+		b.Fatalf("failed to get collection: %v", err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		item, err := collection.Find(keys[random.Intn(len(keys))])
+		if err != nil || item == nil {
+			b.Fatalf("failed to find key: %v", err)
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	db, keys := openBenchmarkDB(b)
+	random := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	// the keys are inserted between the existing keys, the transaction is rolled back afterwards
+	newKeys := make([][]byte, b.N)
+
+	for i := range newKeys {
+		newKeys[i] = []byte(fmt.Sprintf("%s-", keys[random.Intn(len(keys))]))
+	}
+
+	tx := db.WriteTransaction()
+	defer tx.Rollback()
+
+	collection, err := tx.GetCollection([]byte("collection"))
+	if err != nil {
+		b.Fatalf("failed to get collection: %v", err)
+	}
+
+	b.ResetTimer()
+
+	for _, key := range newKeys {
+		if err = collection.Put(key, key); err != nil {
+			b.Fatalf("failed to put key: %v", err)
+		}
+	}
+}
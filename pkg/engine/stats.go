@@ -0,0 +1,97 @@
+package engine
+
+import "fmt"
+
+// CollectionStats describes the tree of a collection. Sub-collections are counted as items, their trees are not
+// included.
+type CollectionStats struct {
+	// KeyCount defines the number of keys that hold a value.
+	KeyCount int
+	// CollectionCount defines the number of sub-collections.
+	CollectionCount int
+	// Depth defines the number of levels of the tree, which is zero for a collection without root.
+	Depth int
+	// PageCount defines the number of pages of the tree, including overflow pages.
+	PageCount int
+	// OverflowPageCount defines the number of pages used by values that are stored outside of the nodes.
+	OverflowPageCount int
+	// LeafFill defines the average share of a leaf page that is used by its items, from 0 to 1.
+	LeafFill float64
+	// BytesUsed defines the number of bytes used by the nodes and the values on overflow pages.
+	BytesUsed int
+}
+
+// Stats walks the tree of the collection and returns its statistics. The tree is read through the transaction, so
+// uncommitted changes of a write transaction are included.
+func (c *Collection) Stats() (*CollectionStats, error) {
+	if err := c.tx.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	stats := &CollectionStats{}
+
+	if c.root == 0 {
+		return stats, nil
+	}
+
+	leafPageCount, leafBytes, err := c.collectStats(c.root, 1, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.LeafFill = float64(leafBytes) / float64(leafPageCount*int(c.dal.pageBodySize()))
+
+	return stats, nil
+}
+
+// collectStats adds the statistics of the subtree with given root at given depth and returns the number of leaves and
+// the bytes used by them.
+func (c *Collection) collectStats(pageNumber uint64, depth int, stats *CollectionStats) (int, int, error) {
+	treeNode, err := c.tx.getNode(pageNumber)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	nodeSize := treeNode.size()
+
+	stats.PageCount++
+	stats.BytesUsed += nodeSize
+
+	if depth > stats.Depth {
+		stats.Depth = depth
+	}
+
+	for _, item := range treeNode.items {
+		if item.collection {
+			stats.CollectionCount++
+		} else {
+			stats.KeyCount++
+		}
+
+		if item.overflowPage != 0 || c.dal.overflows(item) {
+			overflowPageCount := c.dal.pagesFor(len(item.value))
+
+			stats.PageCount += overflowPageCount
+			stats.OverflowPageCount += overflowPageCount
+			stats.BytesUsed += len(item.value)
+		}
+	}
+
+	if treeNode.isLeaf() {
+		return 1, nodeSize, nil
+	}
+
+	leafPageCount, leafBytes := 0, 0
+
+	for _, childNode := range treeNode.childNodes {
+		childLeafPageCount, childLeafBytes, err := c.collectStats(childNode, depth+1, stats)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		leafPageCount += childLeafPageCount
+		leafBytes += childLeafBytes
+	}
+
+	return leafPageCount, leafBytes, nil
+}
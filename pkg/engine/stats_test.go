@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 1000, 10)
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		// the value is stored on 3 overflow pages
+		if err = collection.Put([]byte("overflow"), bytes.Repeat([]byte{1}, 1200)); err != nil {
+			return err
+		}
+
+		for i := 0; err == nil && i < 2; i++ {
+			_, err = collection.CreateCollection([]byte(fmt.Sprintf("nested%d", i)))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		stats, err := collection.Stats()
+		if err != nil {
+			return err
+		}
+
+		pageCount, depth := len(treePages(t, tx, collection.root)), 1
+
+		// all leaves are at the same depth
+		treeNode, err := tx.getNode(collection.root)
+		for ; err == nil && !treeNode.isLeaf(); depth++ {
+			treeNode, err = tx.getNode(treeNode.childNodes[0])
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if stats.KeyCount != 1001 || stats.CollectionCount != 2 || stats.OverflowPageCount != 3 {
+			t.Errorf("stats count %d keys, %d collections and %d overflow pages instead of 1001, 2 and 3",
+				stats.KeyCount, stats.CollectionCount, stats.OverflowPageCount)
+		}
+
+		if stats.PageCount != pageCount+3 || stats.Depth != depth {
+			t.Errorf("stats count %d pages and %d levels instead of %d and %d",
+				stats.PageCount, stats.Depth, pageCount+3, depth)
+		}
+
+		if stats.LeafFill < minNodeFillPercent/2 || stats.LeafFill > 1 {
+			t.Errorf("leaves are filled by %f", stats.LeafFill)
+		}
+
+		if stats.BytesUsed < 1000*(len("key00000")+10)+1200 || stats.BytesUsed > stats.PageCount*512 {
+			t.Errorf("stats count %d bytes used on %d pages", stats.BytesUsed, stats.PageCount)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrCollectionExists   = errors.New("collection already exists")
+	ErrCollectionNotFound = errors.New("collection not found")
+)
+
+// collectionPath returns the path of the collection with given name within the collection with given path. Every name
+// is prefixed with its length, so a path is only a prefix of the paths of its sub-collections.
+func collectionPath(parentPath string, name []byte) string {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	length := binary.PutUvarint(buffer, uint64(len(name)))
+
+	return parentPath + string(buffer[:length]) + string(name)
+}
+
+// CreateCollection creates a sub-collection with given name within the collection. It fails if the key is already
+// used by a value or another sub-collection.
+func (c *Collection) CreateCollection(name []byte) (*Collection, error) {
+	if err := c.tx.checkWrite(); err != nil {
+		return nil, err
+	}
+
+	item, err := c.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if item != nil && item.collection {
+		return nil, ErrCollectionExists
+	} else if item != nil {
+		return nil, ErrIncompatibleValue
+	}
+
+	return c.createCollection(name)
+}
+
+// GetCollection returns the sub-collection with given name. Nil is returned if the key is not used.
+func (c *Collection) GetCollection(name []byte) (*Collection, error) {
+	return c.openCollection(name)
+}
+
+// DeleteCollection removes the sub-collection with given name. The pages of the sub-collection and of all collections
+// within it are freed on commit, so none of them must be used anymore. The removed keys of the sub-collection and of
+// the collections within it are passed to the change feed like for TruncateCollection.
+func (c *Collection) DeleteCollection(name []byte) error {
+	if err := c.tx.checkWrite(); err != nil {
+		return err
+	}
+
+	collection, err := c.openCollection(name)
+	if err != nil {
+		return err
+	}
+
+	if collection == nil {
+		return nil
+	}
+
+	if err = collection.free((*Collection).recordRemove); err != nil {
+		return fmt.Errorf("failed to free collection: %w", err)
+	}
+
+	c.tx.forgetCollections(collection.path)
+
+	return c.remove(name, c.flagsCollections())
+}
+
+// TruncateCollection removes all keys and sub-collections of the sub-collection with given name. The collection and
+// its sequence are kept, the pages of its tree are freed on commit and replaced by an empty root.
+func (c *Collection) TruncateCollection(name []byte) error {
+	if err := c.tx.checkWrite(); err != nil {
+		return err
+	}
+
+	collection, err := c.openCollection(name)
+	if err != nil {
+		return err
+	}
+
+	if collection == nil {
+		return ErrCollectionNotFound
+	}
+
+	return collection.truncate()
+}
+
+// truncate replaces the tree of the collection by an empty root. The removed keys are passed to the change feed.
+func (c *Collection) truncate() error {
+	if err := c.tx.checkOpen(); err != nil {
+		return err
+	}
+
+	if err := c.free((*Collection).recordRemove); err != nil {
+		return fmt.Errorf("failed to free collection: %w", err)
+	}
+
+	// the sub-collections are removed, but the collection itself stays cached
+	c.tx.forgetCollections(c.path)
+	c.tx.collections[c.path] = c
+
+	root := c.tx.writeNode(c.tx.newNode([]*Item{}, []uint64{}))
+	c.root = root.pageNumber
+	c.counter = 0
+	c.uncounted = false
+	c.dirty = true
+
+	return nil
+}
+
+// flagsCollections returns if the items of the collection that hold a collection are flagged. The collections of the
+// root collection are stored as plain items, since they were stored that way before sub-collections existed.
+func (c *Collection) flagsCollections() bool {
+	return c != c.tx.rootCollection
+}
+
+// createCollection creates a collection with given name within the collection without checking whether the key is
+// used already.
+func (c *Collection) createCollection(name []byte) (*Collection, error) {
+	// the empty root is written through the transaction, so it is dropped on rollback
+	root := c.tx.writeNode(c.tx.newNode([]*Item{}, []uint64{}))
+
+	collection := newCollection(name, root.pageNumber)
+	collection.dirty = true
+
+	if err := c.putCollection(collection); err != nil {
+		return nil, err
+	}
+
+	return c.attach(collection), nil
+}
+
+// openCollection returns the collection with given name within the collection. It is cached by the transaction, so
+// changes of its root are stored on commit.
+func (c *Collection) openCollection(name []byte) (*Collection, error) {
+	if collection, ok := c.tx.collections[collectionPath(c.path, name)]; ok {
+		return collection, nil
+	}
+
+	item, err := c.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if item == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	if item.collection != c.flagsCollections() {
+		return nil, ErrIncompatibleValue
+	}
+
+	collection := &Collection{}
+
+	if err = collection.deserialize(item); err != nil {
+		return nil, fmt.Errorf("failed to deserialize collection: %w", err)
+	}
+
+	return c.attach(collection), nil
+}
+
+// attach makes given collection a collection within the collection and caches it in the transaction.
+func (c *Collection) attach(collection *Collection) *Collection {
+	collection.dal = c.dal
+	collection.tx = c.tx
+	collection.parent = c
+	collection.path = collectionPath(c.path, collection.name)
+	c.tx.collections[collection.path] = collection
+
+	return collection
+}
+
+// putCollection stores given collection within the collection.
+func (c *Collection) putCollection(collection *Collection) error {
+	item := collection.serialize()
+	item.collection = c.flagsCollections()
+
+	return c.put(item)
+}
+
+// depth returns the number of collections that contain the collection, including the root collection.
+func (c *Collection) depth() int {
+	depth := 0
+
+	for parent := c.parent; parent != nil; parent = parent.parent {
+		depth++
+	}
+
+	return depth
+}
+
+// free releases the pages of the collection on commit, including overflow pages and the pages of the collections
+// within it. All items of the collection and of the collections within it are passed to given function together with
+// the collection that holds them.
+func (c *Collection) free(visit func(collection *Collection, item *Item)) error {
+	if c.root == 0 {
+		return nil
+	}
+
+	return c.freeTree(c.root, visit)
+}
+
+// freeTree releases the pages of the subtree with given root on commit and passes all items to given function.
+func (c *Collection) freeTree(pageNumber uint64, visit func(collection *Collection, item *Item)) error {
+	treeNode, err := c.tx.getNode(pageNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+
+	for _, item := range treeNode.items {
+		if item.collection {
+			collection, err := c.openCollection(item.key)
+			if err == nil {
+				err = collection.free(visit)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		visit(c, item)
+		c.tx.freeItem(item)
+	}
+
+	for _, childNode := range treeNode.childNodes {
+		if err = c.freeTree(childNode, visit); err != nil {
+			return err
+		}
+	}
+
+	c.tx.deleteNode(treeNode)
+
+	return nil
+}
+
+// forgetCollections removes the collection with given path and all collections within it from the cache of the
+// transaction, so they are not stored on commit.
+func (t *Transaction) forgetCollections(path string) {
+	for cachedPath := range t.collections {
+		if strings.HasPrefix(cachedPath, path) {
+			delete(t.collections, cachedPath)
+		}
+	}
+}
+
+// moveCollections changes the path of the cached collection with given old path and of the collections within it.
+func (t *Transaction) moveCollections(oldPath string, newPath string) {
+	moved := make([]*Collection, 0)
+
+	for path, collection := range t.collections {
+		if strings.HasPrefix(path, oldPath) {
+			delete(t.collections, path)
+			moved = append(moved, collection)
+		}
+	}
+
+	for _, collection := range moved {
+		collection.path = newPath + collection.path[len(oldPath):]
+		t.collections[collection.path] = collection
+	}
+}
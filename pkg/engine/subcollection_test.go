@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDropRecreateFileSize drops and recreates a collection in many commits and reopens the database in between. The
+// pages of the dropped trees have to be reused, so the file stops growing.
+func TestDropRecreateFileSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		drop func(tx *Transaction) (*Collection, error)
+	}{
+		{name: "delete", drop: func(tx *Transaction) (*Collection, error) {
+			if err := tx.DeleteCollection([]byte("collection")); err != nil {
+				return nil, err
+			}
+
+			return tx.CreateCollection([]byte("collection"))
+		}},
+		{name: "truncate", drop: func(tx *Transaction) (*Collection, error) {
+			if err := tx.TruncateCollection([]byte("collection")); err != nil {
+				return nil, err
+			}
+
+			return tx.GetCollection([]byte("collection"))
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "db")
+			size := int64(0)
+
+			for cycle := 0; cycle < 40; cycle++ {
+				// the first cycles release the pages of the initial tree, afterwards the file has to keep its size
+				if cycle == 10 {
+					size = fileSize(t, path)
+				}
+
+				dropRecreate(t, path, test.drop)
+			}
+
+			if grown := fileSize(t, path); grown != size {
+				t.Fatalf("the file grew from %d to %d bytes", size, grown)
+			}
+		})
+	}
+}
+
+// dropRecreate opens the database at given path, puts keys into the collection returned by given function within
+// one commit and closes the database. The database is created with the collection if it does not exist.
+func dropRecreate(t *testing.T, path string, drop func(tx *Transaction) (*Collection, error)) {
+	t.Helper()
+
+	db, err := Open(path, &Options{PageSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	err = db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err == nil && collection == nil {
+			collection, err = tx.CreateCollection([]byte("collection"))
+		} else if err == nil {
+			collection, err = drop(tx)
+		}
+
+		for i := 0; err == nil && i < 2000; i++ {
+			err = collection.Put([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to recreate collection: %v", err)
+	}
+
+	if released := db.freelist.count(); released == 0 {
+		t.Fatal("the pages of the dropped collection were not freed")
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+}
+
+// fileSize returns the size of the file at given path.
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to get file size: %v", err)
+	}
+
+	return info.Size()
+}
+
+// TestIncompatibleValue overwrites and removes a key that holds a sub-collection like a value. Both fail without
+// changing the collection, so it is not stored again on commit.
+func TestIncompatibleValue(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 100, 10)
+
+	update(t, db, func(collection *Collection) error {
+		_, err := collection.CreateCollection([]byte("nested"))
+
+		return err
+	})
+
+	root := db.meta.rootPageNumber
+
+	update(t, db, func(collection *Collection) error {
+		if err := collection.Put([]byte("nested"), []byte("value")); !errors.Is(err, ErrIncompatibleValue) {
+			t.Errorf("overwriting a sub-collection returned %v", err)
+		}
+
+		if err := collection.Remove([]byte("nested")); !errors.Is(err, ErrIncompatibleValue) {
+			t.Errorf("removing a sub-collection returned %v", err)
+		}
+
+		if collection.dirty {
+			t.Error("the collection was marked as changed")
+		}
+
+		return nil
+	})
+
+	if db.meta.rootPageNumber != root {
+		t.Fatal("the unchanged collection was stored again on commit")
+	}
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err == nil {
+			collection, err = collection.GetCollection([]byte("nested"))
+		}
+
+		if err == nil && collection == nil {
+			t.Fatal("the sub-collection was removed")
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to get sub-collection: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// newSyncer creates a syncer and starts flushing given function every interval.
+func newSyncer(interval time.Duration, flush func() error) *syncer {
+	s := &syncer{
+		flush: flush,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go s.run(interval)
+
+	return s
+}
+
+// syncer flushes the database file periodically in the background. Since nobody waits for a background flush, its
+// error is kept and reported on the next commit or on close.
+type syncer struct {
+	err   error
+	flush func() error
+	stop  chan struct{}
+	done  chan struct{}
+	lock  sync.Mutex
+}
+
+// run flushes every interval until the syncer is closed.
+func (s *syncer) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	defer func() {
+		ticker.Stop()
+		close(s.done)
+	}()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				s.lock.Lock()
+				s.err = err
+				s.lock.Unlock()
+			}
+		}
+	}
+}
+
+// error returns and clears the error of the last failed background flush.
+func (s *syncer) error() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.err
+	s.err = nil
+
+	return err
+}
+
+// close stops the background flushes and performs a final one.
+func (s *syncer) close() error {
+	close(s.stop)
+	<-s.done
+
+	if err := s.error(); err != nil {
+		return err
+	}
+
+	return s.flush()
+}
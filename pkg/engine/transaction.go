@@ -1,36 +1,106 @@
 package engine
 
-import "fmt"
-
-// newTransaction creates a new transaction.
-func newTransaction(db *DB, write bool) *Transaction {
-	return &Transaction{
-		db,
-		map[uint64]*node{},
-		make([]uint64, 0),
-		make([]uint64, 0),
-		write,
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrTxClosed  = errors.New("transaction is already committed or rolled back")
+	ErrTxManaged = errors.New("managed transaction can't be committed manually")
+)
+
+// newTransaction creates a new transaction on the snapshot described by given meta.
+func newTransaction(ctx context.Context, db *DB, snapshot meta, write bool) *Transaction {
+	transaction := &Transaction{
+		ctx:                  ctx,
+		db:                   db,
+		meta:                 snapshot,
+		dirtyNodes:           map[uint64]*node{},
+		collections:          map[string]*Collection{},
+		pagesToDelete:        make([]uint64, 0),
+		allocatedPageNumbers: make([]uint64, 0),
+		write:                write,
 	}
+
+	transaction.rootCollection = newCollection(nil, snapshot.rootPageNumber)
+	transaction.rootCollection.dal = db.dal
+	transaction.rootCollection.tx = transaction
+
+	return transaction
 }
 
 // Transaction defines a transaction.
 type Transaction struct {
+	meta                 meta
+	ctx                  context.Context //nolint:containedctx
 	db                   *DB
+	rootCollection       *Collection
 	dirtyNodes           map[uint64]*node
+	collections          map[string]*Collection
 	pagesToDelete        []uint64
 	allocatedPageNumbers []uint64
-	write                bool
+	savepoints           []*Savepoint
+	// freelist holds the state of the freelist when the transaction started, which is restored on rollback.
+	freelist         *freelist
+	changes          []Change
+	commitHandlers   []func()
+	rollbackHandlers []func()
+	write            bool
+	// managed is set for transactions of DB.Update and DB.View, which are committed by the database.
+	managed bool
+	closed  bool
+}
+
+// checkOpen returns ErrTxClosed once the transaction is committed or rolled back, since its nodes and pages must not be
+// used anymore. Otherwise the error of its context is returned, if any.
+func (t *Transaction) checkOpen() error {
+	if t.closed {
+		return ErrTxClosed
+	}
+
+	return t.ctx.Err()
+}
+
+// checkWrite returns an error if the transaction can't perform a write operation, since it is a read transaction or
+// not open anymore.
+func (t *Transaction) checkWrite() error {
+	if !t.write {
+		return ErrWriteInsideReadTx
+	}
+
+	return t.checkOpen()
+}
+
+// allocatePage returns a free page number and keeps track of it, so it can be released on rollback.
+func (t *Transaction) allocatePage() uint64 {
+	pageNumber := t.db.getNextPage()
+	t.allocatedPageNumbers = append(t.allocatedPageNumbers, pageNumber)
+
+	return pageNumber
+}
+
+// allocatePages returns the first page number of count consecutive free pages and keeps track of them.
+func (t *Transaction) allocatePages(count int) uint64 {
+	first := t.db.allocate(count)
+
+	for i := 0; i < count; i++ {
+		t.allocatedPageNumbers = append(t.allocatedPageNumbers, first+uint64(i))
+	}
+
+	return first
 }
 
 func (t *Transaction) newNode(items []*Item, childNodes []uint64) *node {
 	newNode := newEmptyNode()
 	newNode.items = items
 	newNode.childNodes = childNodes
-	newNode.pageNumber = t.db.getNextPage()
+	newNode.pageNumber = t.allocatePage()
+	newNode.dal = t.db.dal
 	newNode.tx = t
 
-	newNode.tx.allocatedPageNumbers = append(newNode.tx.allocatedPageNumbers, newNode.pageNumber)
-
 	return newNode
 }
 
@@ -67,122 +137,314 @@ func (t *Transaction) deleteNode(node *node) {
 	t.pagesToDelete = append(t.pagesToDelete, node.pageNumber)
 }
 
-// Rollback undo transaction changes by deleting newly allocated pages and dropping dirty nodes.
+// Rollback undo transaction changes by restoring the freelist and dropping dirty nodes. Pages are only written on
+// commit, so the file is left untouched. It has no effect on a
+// closed or a managed transaction, a managed transaction is rolled back by returning an error instead.
 func (t *Transaction) Rollback() {
-	if !t.write {
-		t.db.rwlock.RUnlock()
-
+	if t.managed {
 		return
 	}
 
-	t.dirtyNodes = nil
-	t.pagesToDelete = nil
+	t.rollback()
+}
 
-	for _, pageNumber := range t.allocatedPageNumbers {
-		t.db.freelist.releasePage(pageNumber)
+// rollback undo transaction changes, releases the lock of the transaction and calls the rollback handlers.
+func (t *Transaction) rollback() {
+	if t.closed {
+		return
 	}
 
-	t.allocatedPageNumbers = nil
+	t.closed = true
+
+	if t.write {
+		t.dirtyNodes = nil
+		t.collections = nil
+		t.pagesToDelete = nil
+		t.changes = nil
+		t.allocatedPageNumbers = nil
 
-	t.db.rwlock.Unlock()
+		// restoring the freelist undoes all allocations exactly, including the order of the released pages
+		t.db.freelist.restore(t.freelist)
+
+		<-t.db.writeLock
+	} else {
+		t.db.closeReadTransaction(t.meta.txid)
+	}
+
+	for _, handler := range t.rollbackHandlers {
+		handler()
+	}
 }
 
-// Commit commits changes from dirty node and removing lock.
+// Commit commits changes from dirty node and removing lock. Dirty nodes are written copy-on-write to new pages, so
+// the pages of the previous commit stay untouched. The new root is published by writing the next meta page last,
+// which makes the commit atomic: a crash before that leaves the previous commit as the latest valid one. A failed
+// commit rolls the transaction back.
 func (t *Transaction) Commit() error {
+	if t.managed {
+		return ErrTxManaged
+	}
+
+	return t.commit()
+}
+
+// commit commits the transaction and releases the lock of the transaction.
+func (t *Transaction) commit() error {
+	if t.closed {
+		return ErrTxClosed
+	}
+
 	if !t.write {
-		t.db.rwlock.RUnlock()
+		t.closed = true
+		t.db.closeReadTransaction(t.meta.txid)
+		t.callCommitHandlers()
 
 		return nil
 	}
 
-	for _, node := range t.dirtyNodes {
-		if _, err := t.db.writeNode(node); err != nil {
-			return fmt.Errorf("failed to write dirty node to file: %w", err)
-		}
+	if err := t.ctx.Err(); err != nil {
+		t.rollback()
+
+		return err
 	}
 
-	for _, pageNum := range t.pagesToDelete {
-		t.db.deleteNode(pageNum)
+	pagesToCommit, metadata, err := t.spill()
+	if err != nil {
+		t.rollback()
+
+		return err
 	}
 
-	if err := t.db.writeFreelist(); err != nil {
-		return fmt.Errorf("failed to write freelist to file: %w", err)
+	if err = t.db.commit(pagesToCommit, t.db.newMetaPage(*metadata)); err != nil {
+		t.db.freelist.rollbackPending(metadata.txid)
+		t.rollback()
+
+		return fmt.Errorf("failed to commit pages to file: %w", err)
 	}
 
+	t.db.publish(metadata)
+	// the change feed is notified before the lock is released, so the notifications are in commit order
+	t.db.notify(metadata.txid, t.changes)
+
+	t.closed = true
 	t.dirtyNodes = nil
+	t.collections = nil
 	t.pagesToDelete = nil
 	t.allocatedPageNumbers = nil
+	t.changes = nil
+
+	<-t.db.writeLock
 
-	t.db.rwlock.Unlock()
+	t.callCommitHandlers()
 
 	return nil
 }
 
-func (t *Transaction) getRootCollection() *Collection {
-	rootCollection := &Collection{}
-	rootCollection.root = t.db.dal.rootPageNumber
-	rootCollection.tx = t
+// OnCommit registers given handler, which is called after the transaction is committed successfully.
+func (t *Transaction) OnCommit(handler func()) {
+	t.commitHandlers = append(t.commitHandlers, handler)
+}
 
-	return rootCollection
+// OnRollback registers given handler, which is called after the transaction is rolled back. This includes a failed
+// commit and rolling back to a savepoint created before the handler was registered.
+func (t *Transaction) OnRollback(handler func()) {
+	t.rollbackHandlers = append(t.rollbackHandlers, handler)
 }
 
-// GetCollection returns collection by name.
-func (t *Transaction) GetCollection(name []byte) (*Collection, error) {
-	rootCollection := t.getRootCollection()
+// callCommitHandlers calls the commit handlers.
+func (t *Transaction) callCommitHandlers() {
+	for _, handler := range t.commitHandlers {
+		handler()
+	}
+}
 
-	item, err := rootCollection.Find(name)
-	if err != nil {
-		return nil, err
+// spill serializes all changes of the transaction into new pages and returns them together with the metadata that
+// publishes them. The changed collections are stored in their parent collections first, since their roots move and
+// storing them changes the parent. Therefore, sub-collections are stored before the collections that contain them.
+// Collections that were only read keep their root and are not stored again.
+func (t *Transaction) spill() ([]*page, *meta, error) {
+	pagesToCommit := make([]*page, 0, len(t.dirtyNodes)+1)
+	collections := make([]*Collection, 0, len(t.collections))
+
+	for _, collection := range t.collections {
+		collections = append(collections, collection)
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].depth() > collections[j].depth()
+	})
+
+	for _, collection := range collections {
+		if !collection.dirty {
+			continue
+		}
+
+		// A collection stored by an older version is counted before it is stored, since its counter is considered to
+		// be maintained afterwards. The tree has to be counted before its dirty nodes are moved to unwritten pages.
+		if err := collection.count(); err != nil {
+			return nil, nil, fmt.Errorf("failed to update collection: %w", err)
+		}
+
+		collection.root, pagesToCommit = t.spillNode(collection.root, pagesToCommit)
+
+		if err := collection.parent.putCollection(collection); err != nil {
+			return nil, nil, fmt.Errorf("failed to update collection: %w", err)
+		}
 	}
 
-	if item == nil {
-		return nil, nil //nolint:nilnil
+	metadata := t.meta
+	metadata.txid++
+	metadata.rootPageNumber, pagesToCommit = t.spillNode(t.rootCollection.root, pagesToCommit)
+
+	for i := 0; i < t.db.freelist.pageCount; i++ {
+		t.pagesToDelete = append(t.pagesToDelete, t.meta.freelistPageNumber+uint64(i))
 	}
 
-	collection := &Collection{}
+	// the freelist has to hold the pages freed below as well
+	freelistPageCount := t.db.pagesFor(t.db.freelist.size() + len(t.pagesToDelete)*pageNumberSize)
+	metadata.freelistPageNumber = t.allocatePages(freelistPageCount)
 
-	collection.deserialize(item)
+	// The pages of the previous commit are freed after all pages of this commit are allocated, otherwise they could
+	// be overwritten before the new meta page is written. They stay pending while older snapshots are read.
+	t.db.freelist.free(metadata.txid, t.pagesToDelete)
 
-	collection.tx = t
+	t.db.freelist.pageCount = freelistPageCount
+	pagesToCommit = append(pagesToCommit, t.db.newFreelistPages(metadata.freelistPageNumber)...)
 
-	return collection, nil
+	return pagesToCommit, &metadata, nil
 }
 
-func (t *Transaction) CreateCollection(name []byte) (*Collection, error) {
-	if !t.write {
-		return nil, ErrWriteInsideReadTx
+// spillNode serializes the dirty nodes of the subtree with given root and returns the new page number of the root.
+// Nodes that already exist on file are moved to a new page, which requires the ancestors of a dirty node to be dirty
+// as well. Nodes that are not reachable from a root are dropped, since they were removed from the tree.
+func (t *Transaction) spillNode(pageNumber uint64, pagesToCommit []*page) (uint64, []*page) {
+	dirtyNode, ok := t.dirtyNodes[pageNumber]
+	if !ok {
+		return pageNumber, pagesToCommit
 	}
 
-	newCollectionPage, err := t.db.dal.writeNode(newEmptyNode())
-	if err != nil {
-		return nil, err
+	delete(t.dirtyNodes, pageNumber)
+
+	for i, childNode := range dirtyNode.childNodes {
+		dirtyNode.childNodes[i], pagesToCommit = t.spillNode(childNode, pagesToCommit)
+	}
+
+	if !t.isAllocated(pageNumber) {
+		t.pagesToDelete = append(t.pagesToDelete, pageNumber)
+		dirtyNode.pageNumber = t.allocatePage()
+	}
+
+	for _, item := range dirtyNode.items {
+		if item.overflowPage == 0 && t.db.overflows(item) {
+			item.overflowPage = t.allocatePages(t.db.pagesFor(len(item.value)))
+			pagesToCommit = append(pagesToCommit, t.db.newPages(item.overflowPage, item.value)...)
+		}
 	}
 
-	newCollection := &Collection{}
-	newCollection.name = name
-	newCollection.root = newCollectionPage.number
+	return dirtyNode.pageNumber, append(pagesToCommit, t.db.newNodePage(dirtyNode))
+}
+
+// freeItem releases the overflow pages of given item on commit, since the item was removed or overwritten.
+func (t *Transaction) freeItem(item *Item) {
+	if item.overflowPage == 0 {
+		return
+	}
 
-	return t.createCollection(newCollection)
+	for i := 0; i < t.db.pagesFor(len(item.value)); i++ {
+		t.pagesToDelete = append(t.pagesToDelete, item.overflowPage+uint64(i))
+	}
 }
 
-func (t *Transaction) createCollection(collection *Collection) (*Collection, error) {
-	collection.tx = t
-	collectionBytes := collection.serialize()
-	rootCollection := t.getRootCollection()
+// isAllocated returns if the page with given number was allocated within this transaction.
+func (t *Transaction) isAllocated(pageNumber uint64) bool {
+	for _, allocatedPageNumber := range t.allocatedPageNumbers {
+		if allocatedPageNumber == pageNumber {
+			return true
+		}
+	}
 
-	if err := rootCollection.Put(collection.name, collectionBytes.value); err != nil {
+	return false
+}
+
+// GetCollection returns collection by name.
+func (t *Transaction) GetCollection(name []byte) (*Collection, error) {
+	return t.rootCollection.openCollection(name)
+}
+
+func (t *Transaction) CreateCollection(name []byte) (*Collection, error) {
+	if err := t.checkWrite(); err != nil {
 		return nil, err
 	}
 
-	return collection, nil
+	return t.rootCollection.createCollection(name)
 }
 
+// DeleteCollection removes the collection with given name. The pages of the collection and of all collections within
+// it are freed on commit.
 func (t *Transaction) DeleteCollection(name []byte) error {
-	if !t.write {
-		return ErrWriteInsideReadTx
+	return t.rootCollection.DeleteCollection(name)
+}
+
+// TruncateCollection removes all keys and sub-collections of the collection with given name. The collection and its
+// sequence are kept, the pages of its tree are freed on commit.
+func (t *Transaction) TruncateCollection(name []byte) error {
+	return t.rootCollection.TruncateCollection(name)
+}
+
+// ListCollections returns the names of the collections starting with given prefix in ascending order. A nil prefix
+// lists all collections.
+func (t *Transaction) ListCollections(prefix []byte) ([][]byte, error) {
+	names := make([][]byte, 0)
+
+	err := t.rootCollection.ScanPrefix(prefix, func(item *Item) bool {
+		names = append(names, item.key)
+
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan collections: %w", err)
 	}
 
-	rootCollection := t.getRootCollection()
+	return names, nil
+}
+
+// RenameCollection renames the collection with given old name. Only the serialized collection is moved, the pages of
+// the collection are kept. Collections opened before stay valid and carry the new name.
+func (t *Transaction) RenameCollection(oldName []byte, newName []byte) error {
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+
+	collection, err := t.rootCollection.openCollection(oldName)
+	if err != nil {
+		return err
+	}
 
-	return rootCollection.Remove(name)
+	if collection == nil {
+		return ErrCollectionNotFound
+	}
+
+	existing, err := t.rootCollection.find(newName)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return ErrCollectionExists
+	}
+
+	if err = t.rootCollection.remove(oldName, false); err != nil {
+		return err
+	}
+
+	collection.name = newName
+	collection.dirty = true
+
+	if err = t.rootCollection.putCollection(collection); err != nil {
+		return err
+	}
+
+	t.moveCollections(collection.path, collectionPath(t.rootCollection.path, newName))
+
+	return nil
 }
@@ -0,0 +1,589 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestClosedTransaction uses transactions and their collections after they were closed. Writes panicked on commit
+// and allocated pages without holding the write lock.
+func TestClosedTransaction(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	putKeys(t, db, 10, 10)
+
+	write := db.WriteTransaction()
+
+	writeCollection, err := write.GetCollection([]byte("collection"))
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+
+	if err = write.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	read := db.ReadTransaction()
+
+	readCollection, err := read.GetCollection([]byte("collection"))
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+
+	read.Rollback()
+
+	maxPage := db.freelist.maxPage
+	operations := map[string]func(tx *Transaction, collection *Collection) error{
+		"Put":    func(_ *Transaction, c *Collection) error { return c.Put([]byte("key"), []byte("value")) },
+		"Remove": func(_ *Transaction, c *Collection) error { return c.Remove([]byte("key00001")) },
+		"Find": func(_ *Transaction, c *Collection) error {
+			_, err := c.Find([]byte("key00001"))
+
+			return err
+		},
+		"Scan": func(_ *Transaction, c *Collection) error {
+			return c.Scan(nil, nil, func(*Item) bool { return true })
+		},
+		"Cursor": func(_ *Transaction, c *Collection) error {
+			_, err := c.Cursor().First()
+
+			return err
+		},
+		"NextSequence": func(_ *Transaction, c *Collection) error {
+			_, err := c.NextSequence()
+
+			return err
+		},
+		"CreateCollection": func(tx *Transaction, _ *Collection) error {
+			_, err := tx.CreateCollection([]byte("created"))
+
+			return err
+		},
+		"GetCollection": func(tx *Transaction, _ *Collection) error {
+			_, err := tx.GetCollection([]byte("other"))
+
+			return err
+		},
+		"DeleteCollection": func(tx *Transaction, _ *Collection) error {
+			return tx.DeleteCollection([]byte("collection"))
+		},
+		"TruncateCollection": func(tx *Transaction, _ *Collection) error {
+			return tx.TruncateCollection([]byte("collection"))
+		},
+		"RenameCollection": func(tx *Transaction, _ *Collection) error {
+			return tx.RenameCollection([]byte("collection"), []byte("renamed"))
+		},
+		"Savepoint": func(tx *Transaction, _ *Collection) error {
+			_, err := tx.Savepoint()
+
+			return err
+		},
+		"Commit": func(tx *Transaction, _ *Collection) error { return tx.Commit() },
+	}
+
+	for name, operation := range operations {
+		if writeErr := operation(write, writeCollection); !errors.Is(writeErr, ErrTxClosed) {
+			t.Errorf("%s of a committed transaction returned %v instead of ErrTxClosed", name, writeErr)
+		}
+
+		// write operations of a read transaction are rejected as such
+		readErr := operation(read, readCollection)
+		if !errors.Is(readErr, ErrTxClosed) && !errors.Is(readErr, ErrWriteInsideReadTx) {
+			t.Errorf("%s of a rolled back transaction returned %v instead of ErrTxClosed", name, readErr)
+		}
+	}
+
+	if db.freelist.maxPage != maxPage {
+		t.Fatalf("closed transactions allocated pages up to %d", db.freelist.maxPage)
+	}
+}
+
+func TestRandomRollback(t *testing.T) {
+	t.Parallel()
+
+	for seed := int64(1); seed <= 5; seed++ {
+		seed := seed
+
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			t.Parallel()
+			randomRollback(t, seed)
+		})
+	}
+}
+
+// randomRollback alternates between committed and rolled back transactions of random operations. After every rollback
+// the file has to be byte-identical to the file before the transaction and the freelist has to be restored.
+func randomRollback(t *testing.T, seed int64) {
+	t.Helper()
+
+	random := rand.New(rand.NewSource(seed)) //nolint:gosec
+	path := filepath.Join(t.TempDir(), "db")
+
+	db, err := Open(path, &Options{PageSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	expected := putKeys(t, db, 200, 50)
+
+	for round := 0; round < 30; round++ {
+		before, maxPage, freePages := readFile(t, path), db.freelist.maxPage, db.freelist.count()
+		commit := random.Intn(2) == 0
+		changed := copyMap(expected)
+
+		tx := db.WriteTransaction()
+
+		for i := 0; err == nil && i < 50; i++ {
+			err = randomOperation(tx, random, changed)
+		}
+
+		if err != nil {
+			t.Fatalf("round %d failed: %v", round, err)
+		}
+
+		if commit {
+			if err = tx.Commit(); err != nil {
+				t.Fatalf("failed to commit round %d: %v", round, err)
+			}
+
+			expected = changed
+		} else {
+			tx.Rollback()
+
+			if !bytes.Equal(before, readFile(t, path)) {
+				t.Fatalf("the file was changed by the rolled back round %d", round)
+			}
+
+			if db.freelist.maxPage != maxPage || db.freelist.count() != freePages {
+				t.Fatalf("the freelist was not restored by the rolled back round %d", round)
+			}
+		}
+
+		checkCommitted(t, db, expected)
+	}
+}
+
+// randomOperation performs a random operation within given write transaction. Only the keys of the collection of
+// putKeys are tracked by given map, the other operations change further collections.
+func randomOperation(tx *Transaction, random *rand.Rand, expected map[string][]byte) error {
+	collection, err := tx.GetCollection([]byte("collection"))
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("key%05d", random.Intn(300))
+	name := []byte(fmt.Sprintf("other%d", random.Intn(3)))
+
+	switch random.Intn(11) {
+	case 0, 1, 2, 3:
+		// some values are stored on overflow pages
+		value := bytes.Repeat([]byte{byte(random.Intn(256))}, random.Intn(700))
+		expected[key] = value
+
+		return collection.Put([]byte(key), value)
+	case 4, 5:
+		delete(expected, key)
+
+		return collection.Remove([]byte(key))
+	case 6:
+		_, err = collection.NextSequence()
+
+		return err
+	case 7:
+		var other *Collection
+
+		other, err = tx.GetCollection(name)
+		if err == nil && other == nil {
+			other, err = tx.CreateCollection(name)
+		}
+
+		if err == nil {
+			_, err = other.CreateCollection([]byte(key))
+		}
+	case 8:
+		return tx.DeleteCollection(name)
+	case 9:
+		err = tx.TruncateCollection(name)
+	default:
+		err = tx.RenameCollection(name, []byte(fmt.Sprintf("other%d", random.Intn(3))))
+	}
+
+	if errors.Is(err, ErrCollectionNotFound) || errors.Is(err, ErrCollectionExists) {
+		return nil
+	}
+
+	return err
+}
+
+// readFile returns the content of the file at given path.
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	return content
+}
+
+// copyMap returns a copy of given map.
+func copyMap(original map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(original))
+
+	for key, value := range original {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+// TestCommitHandlers checks that the commit handlers are called once in order after a successful commit and that only
+// the rollback handlers are called if the commit fails.
+func TestCommitHandlers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// commit commits given transaction, which is expected to fail if failed is set
+		commit func(db *DB, tx *Transaction, cancel func()) error
+		failed bool
+	}{
+		{name: "commit", commit: func(_ *DB, tx *Transaction, _ func()) error { return tx.Commit() }},
+		{name: "cancel", failed: true, commit: func(_ *DB, tx *Transaction, cancel func()) error {
+			cancel()
+
+			return tx.Commit()
+		}},
+		{name: "write", failed: true, commit: func(db *DB, tx *Transaction, _ func()) error {
+			file := db.file
+			defer func() { db.file = file }()
+
+			readOnly, err := os.Open(file.Name())
+			if err != nil {
+				t.Fatalf("failed to open file: %v", err)
+			}
+
+			defer func() { _ = readOnly.Close() }()
+
+			// writing the pages fails, since the file is opened read-only
+			db.file = readOnly
+
+			return tx.Commit()
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := openTestDB(t, 512)
+			expected := putKeys(t, db, 10, 10)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tx, err := db.BeginTx(ctx, &TxOptions{Writable: true})
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+
+			calls := make([]string, 0)
+
+			tx.OnCommit(func() { calls = append(calls, "commit 1") })
+			tx.OnRollback(func() { calls = append(calls, "rollback 1") })
+			tx.OnCommit(func() { calls = append(calls, "commit 2") })
+			tx.OnRollback(func() { calls = append(calls, "rollback 2") })
+
+			collection, err := tx.GetCollection([]byte("collection"))
+			if err == nil {
+				err = collection.Put([]byte("key"), []byte("value"))
+			}
+
+			if err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+
+			expectedCalls := []string{"commit 1", "commit 2"}
+
+			err = test.commit(db, tx, cancel)
+			if test.failed {
+				expectedCalls = []string{"rollback 1", "rollback 2"}
+
+				if err == nil {
+					t.Fatal("the commit did not fail")
+				}
+			} else {
+				expected["key"] = []byte("value")
+
+				if err != nil {
+					t.Fatalf("failed to commit: %v", err)
+				}
+			}
+
+			// closing the transaction again has no effect
+			tx.Rollback()
+
+			if fmt.Sprint(calls) != fmt.Sprint(expectedCalls) {
+				t.Fatalf("the handlers were called as %v instead of %v", calls, expectedCalls)
+			}
+
+			checkCommitted(t, db, expected)
+		})
+	}
+}
+
+func TestListCollections(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+
+	err := db.Update(func(tx *Transaction) error {
+		for _, name := range []string{"c", "b2", "a", "b1"} {
+			if _, err := tx.CreateCollection([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		// sub-collections are listed by their parent only
+		collection, err := tx.GetCollection([]byte("b1"))
+		if err == nil {
+			_, err = collection.CreateCollection([]byte("b3"))
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create collections: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		for prefix, expected := range map[string]string{"": "[a b1 b2 c]", "b": "[b1 b2]", "d": "[]"} {
+			names, err := tx.ListCollections([]byte(prefix))
+			if err != nil {
+				return err
+			}
+
+			if listed := fmt.Sprintf("%s", names); listed != expected {
+				t.Errorf("collections with prefix %q are %s instead of %s", prefix, listed, expected)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list collections: %v", err)
+	}
+}
+
+// TestRenameCollection renames a collection that holds sub-collections, including one that was changed within the
+// same transaction before.
+func TestRenameCollection(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+
+	err := db.Update(func(tx *Transaction) error {
+		if _, err := tx.CreateCollection([]byte("taken")); err != nil {
+			return err
+		}
+
+		collection, err := tx.CreateCollection([]byte("source"))
+		if err != nil {
+			return err
+		}
+
+		nested, err := collection.CreateCollection([]byte("nested"))
+		if err != nil {
+			return err
+		}
+
+		deep, err := nested.CreateCollection([]byte("deep"))
+		if err != nil {
+			return err
+		}
+
+		for _, target := range []*Collection{collection, nested, deep} {
+			if err = target.Put([]byte("key"), []byte("value")); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create collections: %v", err)
+	}
+
+	err = db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("source"))
+		if err != nil {
+			return err
+		}
+
+		nested, err := collection.GetCollection([]byte("nested"))
+		if err == nil {
+			err = nested.Put([]byte("changed"), []byte("value"))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err = tx.RenameCollection([]byte("source"), []byte("taken")); !errors.Is(err, ErrCollectionExists) {
+			t.Errorf("renaming onto an existing collection returned %v instead of ErrCollectionExists", err)
+		}
+
+		if err = tx.RenameCollection([]byte("missing"), []byte("other")); !errors.Is(err, ErrCollectionNotFound) {
+			t.Errorf("renaming a missing collection returned %v instead of ErrCollectionNotFound", err)
+		}
+
+		if err = tx.RenameCollection([]byte("source"), []byte("renamed")); err != nil {
+			return err
+		}
+
+		checkRenamed(t, tx)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to rename collection: %v", err)
+	}
+
+	err = db.View(func(tx *Transaction) error {
+		checkRenamed(t, tx)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+}
+
+// checkRenamed fails the test if the collections of TestRenameCollection are not found under their new name.
+func checkRenamed(t *testing.T, tx *Transaction) {
+	t.Helper()
+
+	names, err := tx.ListCollections(nil)
+	if err != nil || fmt.Sprintf("%s", names) != "[renamed taken]" {
+		t.Fatalf("the collections are %s instead of [renamed taken]: %v", names, err)
+	}
+
+	collection, err := tx.GetCollection([]byte("renamed"))
+	if err != nil || collection == nil {
+		t.Fatalf("failed to get renamed collection: %v", err)
+	}
+
+	// each level is the sub-collection of the previous level
+	levels := []struct {
+		name string
+		keys []string
+	}{
+		{name: "renamed", keys: []string{"key"}},
+		{name: "nested", keys: []string{"key", "changed"}},
+		{name: "deep", keys: []string{"key"}},
+	}
+
+	for i, level := range levels {
+		if i > 0 {
+			if collection, err = collection.GetCollection([]byte(level.name)); err != nil || collection == nil {
+				t.Fatalf("failed to get sub-collection %q of renamed collection: %v", level.name, err)
+			}
+		}
+
+		for _, key := range level.keys {
+			if item, err := collection.Find([]byte(key)); err != nil || item == nil {
+				t.Fatalf("key %q of %q was not found after the rename: %v", key, level.name, err)
+			}
+		}
+	}
+}
+
+// TestCancelTransaction cancels the context of a write transaction in the middle of a Scan and before a Put. The
+// operation and the commit have to fail with the error of the context and the transaction has to be rolled back.
+func TestCancelTransaction(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(collection *Collection, cancel func()) error{
+		"scan": func(collection *Collection, cancel func()) error {
+			count := 0
+
+			err := collection.Scan(nil, nil, func(*Item) bool {
+				if count++; count == 10 {
+					cancel()
+				}
+
+				return true
+			})
+			if count != 10 {
+				t.Errorf("the scan continued with %d items after the context was cancelled", count-10)
+			}
+
+			return err
+		},
+		"put": func(collection *Collection, cancel func()) error {
+			cancel()
+
+			return collection.Put([]byte("cancelled"), []byte("value"))
+		},
+	}
+
+	for name, operation := range tests {
+		operation := operation
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			db := openTestDB(t, 512)
+			expected := putKeys(t, db, 100, 10)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tx, err := db.BeginTx(ctx, &TxOptions{Writable: true})
+			if err != nil {
+				t.Fatalf("failed to begin transaction: %v", err)
+			}
+
+			collection, err := tx.GetCollection([]byte("collection"))
+			if err == nil {
+				err = collection.Put([]byte("uncommitted"), []byte("value"))
+			}
+
+			if err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+
+			if err = operation(collection, cancel); !errors.Is(err, context.Canceled) {
+				t.Fatalf("the operation returned %v instead of the error of the context", err)
+			}
+
+			if err = tx.Commit(); !errors.Is(err, context.Canceled) {
+				t.Fatalf("the commit returned %v instead of the error of the context", err)
+			}
+
+			// the rollback released the write lock
+			update(t, db, func(collection *Collection) error {
+				return collection.Put([]byte("key"), []byte("value"))
+			})
+
+			expected["key"] = []byte("value")
+			checkCommitted(t, db, expected)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Upgrade migrates the database file at given path in place to the current format version. Migrated nodes are written
+// to new pages and the migrated meta to the next meta page, so a crash during the upgrade leaves the file in its
+// previous version and the upgrade can simply be repeated. The database must not be open while upgrading.
+func Upgrade(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to get file state: %w", err)
+	}
+
+	dal, err := newDal(path, &Options{SyncMode: SyncAlways})
+	if err != nil {
+		return err
+	}
+
+	if err = dal.upgrade(); err != nil {
+		_ = dal.close()
+
+		return fmt.Errorf("failed to upgrade from version %d: %w", dal.version, err)
+	}
+
+	return dal.close()
+}
+
+// upgrade migrates the file version by version until the current format version is reached.
+func (d *dal) upgrade() error {
+	if d.version > formatVersion {
+		return d.checkFormat()
+	}
+
+	if d.version == formatVersion {
+		return nil
+	}
+
+	if d.version == headerlessFormatVersion || d.version == legacyFormatVersion {
+		// headerless and legacy files were always written with the page size of the host
+		d.meta.pageSize = uint32(d.pageSize)
+		d.flags = 0
+	}
+
+	if d.version == legacyFormatVersion {
+		d.version++
+	}
+
+	// the node format changed with overflowFormatVersion, therefore every node is rewritten
+	return d.rewriteNodes(overflowFormatVersion)
+}
+
+// rewriteNodes reads every node of the file in the current format version and writes it in given version. The nodes
+// are written by a transaction, so they are moved to new pages and the new version is published with the meta.
+func (d *dal) rewriteNodes(version uint32) error {
+	tx := newDB(d, DefaultOptions()).WriteTransaction()
+	names := make([][]byte, 0)
+
+	if tx.rootCollection.root == 0 {
+		// headerless files never stored the root of the root collection
+		tx.rootCollection.root = tx.writeNode(tx.newNode([]*Item{}, []uint64{})).pageNumber
+	}
+
+	err := tx.writeTree(tx.rootCollection.root, func(item *Item) {
+		names = append(names, item.key)
+	})
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	for _, name := range names {
+		collection, err := tx.GetCollection(name)
+		if err == nil {
+			collection.dirty = true
+			err = tx.writeTree(collection.root, func(*Item) {})
+		}
+
+		if err != nil {
+			tx.Rollback()
+
+			return fmt.Errorf("failed to rewrite collection %s: %w", name, err)
+		}
+	}
+
+	d.version = version
+	tx.meta.version = version
+
+	return tx.Commit()
+}
+
+// writeTree marks every node of the tree with given root as dirty and passes all items to given function.
+func (t *Transaction) writeTree(pageNumber uint64, visit func(item *Item)) error {
+	treeNode, err := t.getNode(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	t.writeNode(treeNode)
+
+	for _, item := range treeNode.items {
+		visit(item)
+	}
+
+	for _, childNode := range treeNode.childNodes {
+		if err = t.writeTree(childNode, visit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isHeaderless checks if the file was written before pages had a header, in which case the magic number is at the
+// beginning of the file instead of behind the page header.
+func (d *dal) isHeaderless() bool {
+	metaPage, err := d.readRawPage(metaPageNumber)
+
+	return err == nil && isValidMeta(metaPage.data)
+}
+
+// readHeaderless reads the meta and the freelist of a headerless file. The freelist page is the page the upgraded meta
+// is written to, so it is neither released by the upgrade nor read anymore once the upgraded meta was written. If the
+// upgrade was interrupted while writing that meta, the released pages are lost and only the pages behind the end of
+// the file are known to be free.
+func (d *dal) readHeaderless() (*meta, *freelist, error) {
+	metaPage, err := d.readRawPage(metaPageNumber)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read metadata page from file: %w", err)
+	}
+
+	metadata := newEmptyMeta()
+	metadata.version = headerlessFormatVersion
+	metadata.rootPageNumber = binary.LittleEndian.Uint64(metaPage.data[magicNumberSize:])
+	metadata.freelistPageNumber = binary.LittleEndian.Uint64(metaPage.data[magicNumberSize+pageNumberSize:])
+
+	if metadata.freelistPageNumber != metaPageNumber+1 {
+		return nil, nil, fmt.Errorf("%w: freelist of headerless file on page %d", ErrCorrupt, metadata.freelistPageNumber)
+	}
+
+	freelistPage, err := d.readRawPage(metadata.freelistPageNumber)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read freelist page from file: %w", err)
+	}
+
+	freelist := newFreelist()
+
+	if isValidMeta(freelistPage.body()) {
+		freelist.maxPage = d.maxPageCount() - 1
+	} else if err = freelist.deserialize(freelistPage.data); err != nil {
+		return nil, nil, fmt.Errorf("failed to deserialize freelist page %d: %w", metadata.freelistPageNumber, err)
+	}
+
+	freelist.pageCount = 0
+
+	return metadata, freelist, nil
+}
+
+// getHeaderlessNode returns the node with given page number of a headerless file.
+func (d *dal) getHeaderlessNode(pageNumber uint64) (*node, error) {
+	nodePage, err := d.readRawPage(pageNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node page from page %d: %w", pageNumber, err)
+	}
+
+	node := newEmptyNode()
+
+	if err = node.deserializeLegacy(nodePage.data); err != nil {
+		return nil, fmt.Errorf("failed to deserialize node page %d: %w", pageNumber, err)
+	}
+
+	node.pageNumber = pageNumber
+	node.dal = d
+
+	return node, nil
+}
+
+// deserializeLegacy deserializes a node written before overflowFormatVersion, which stored the lengths of keys and
+// values in a single byte and had neither item flags nor overflow pages.
+func (n *node) deserializeLegacy(buffer []byte) error {
+	if len(buffer) < nodeHeaderSize || buffer[0] > 1 {
+		return errMalformedNode
+	}
+
+	leftPos := 1
+	isLeaf := buffer[0]
+
+	itemsCount := int(binary.LittleEndian.Uint16(buffer[leftPos : leftPos+int16Offset]))
+	leftPos += int16Offset
+
+	for i := 0; i < itemsCount; i++ {
+		if isLeaf == 0 { // False
+			if leftPos+pageNumberSize > len(buffer) {
+				return errMalformedNode
+			}
+
+			pageNum := binary.LittleEndian.Uint64(buffer[leftPos:])
+			leftPos += pageNumberSize
+
+			n.childNodes = append(n.childNodes, pageNum)
+		}
+
+		if leftPos+int16Offset > len(buffer) {
+			return errMalformedNode
+		}
+
+		offset := int(binary.LittleEndian.Uint16(buffer[leftPos:]))
+		leftPos += int16Offset
+
+		if offset >= len(buffer) {
+			return errMalformedNode
+		}
+
+		keyCount := int(buffer[offset])
+		offset += byteOffset
+
+		if offset+keyCount >= len(buffer) {
+			return errMalformedNode
+		}
+
+		key := buffer[offset : offset+keyCount]
+		offset += keyCount
+
+		valueCount := int(buffer[offset])
+		offset += byteOffset
+
+		if offset+valueCount > len(buffer) {
+			return errMalformedNode
+		}
+
+		value := buffer[offset : offset+valueCount]
+		n.items = append(n.items, NewItem(key, value))
+	}
+
+	if isLeaf == 0 {
+		if leftPos+pageNumberSize > len(buffer) {
+			return errMalformedNode
+		}
+
+		pageNum := binary.LittleEndian.Uint64(buffer[leftPos:])
+		n.childNodes = append(n.childNodes, pageNum)
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serializeLegacyLeaf serializes a leaf with given items into given buffer the way nodes were written before
+// overflowFormatVersion.
+func serializeLegacyLeaf(buffer []byte, items []*Item) {
+	buffer[0] = 1
+	binary.LittleEndian.PutUint16(buffer[byteOffset:], uint16(len(items)))
+
+	leftPos, rightPos := nodeHeaderSize, len(buffer)
+
+	for _, item := range items {
+		rightPos -= 2*byteOffset + len(item.key) + len(item.value)
+		binary.LittleEndian.PutUint16(buffer[leftPos:], uint16(rightPos))
+		leftPos += int16Offset
+
+		buffer[rightPos] = byte(len(item.key))
+		copy(buffer[rightPos+byteOffset:], item.key)
+		buffer[rightPos+byteOffset+len(item.key)] = byte(len(item.value))
+		copy(buffer[rightPos+2*byteOffset+len(item.key):], item.value)
+	}
+}
+
+// writeVersion2File writes a database file of format version 2 holding a collection with given name and items. The
+// collection is stored in 16 bytes without a counter like before counters were maintained.
+func writeVersion2File(t *testing.T, path string, name []byte, items []*Item) {
+	t.Helper()
+
+	db, err := Open(path, &Options{PageSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	collectionPage, rootPage := db.freelist.maxPage+1, db.freelist.maxPage+2
+	db.freelist.maxPage += 2
+
+	legacyCollection := make([]byte, legacyCollectionSize)
+	binary.LittleEndian.PutUint64(legacyCollection, collectionPage)
+
+	pages := []*page{db.allocateEmptyPage(), db.allocateEmptyPage()}
+	pages[0].number, pages[1].number = collectionPage, rootPage
+	serializeLegacyLeaf(pages[0].body(), items)
+	serializeLegacyLeaf(pages[1].body(), []*Item{NewItem(name, legacyCollection)})
+
+	metadata := *db.meta
+	metadata.version = 2
+	metadata.rootPageNumber = rootPage
+	metadata.txid++
+
+	pages = append(pages, db.newFreelistPages(metadata.freelistPageNumber)...)
+
+	if err = db.commit(pages, db.newMetaPage(metadata)); err != nil {
+		t.Fatalf("failed to write version 2: %v", err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+}
+
+func TestUpgradeVersion2(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "db")
+	expected := map[string][]byte{}
+	items := make([]*Item, 0)
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", i)
+		expected[key] = bytes.Repeat([]byte{byte(i)}, i*40)
+		items = append(items, NewItem([]byte(key), expected[key]))
+	}
+
+	writeVersion2File(t, path, []byte("collection"), items)
+
+	if _, err := Open(path, nil); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion before the upgrade, got %v", err)
+	}
+
+	if err := Upgrade(path); err != nil {
+		t.Fatalf("failed to upgrade: %v", err)
+	}
+
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("failed to open upgraded database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	checkCommitted(t, db, expected)
+
+	checkLen(t, db, len(items))
+
+	tx := db.WriteTransaction()
+
+	collection, err := tx.GetCollection([]byte("collection"))
+	if err == nil {
+		err = collection.Put([]byte("key5"), []byte("value"))
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		t.Fatalf("failed to put into upgraded collection: %v", err)
+	}
+
+	checkLen(t, db, len(items)+1)
+}
+
+// checkLen fails the test if the committed collection does not hold given number of keys.
+func checkLen(t *testing.T, db *DB, expected int) {
+	t.Helper()
+
+	err := db.View(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		count, err := collection.Len()
+		if err == nil && count != expected {
+			t.Fatalf("collection has %d keys instead of %d", count, expected)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to count keys: %v", err)
+	}
+}
+
+// writeHeaderlessFile writes a database file the way it was written before pages had a header: the meta on page 0,
+// the freelist on page 1 and pages of the host page size. If items are given, the root collection on page 2 holds a
+// collection with given name and items on page 3, otherwise the root of the root collection is zero like in files
+// created by that version. Page 4 is a released page.
+func writeHeaderlessFile(t *testing.T, path string, name []byte, items []*Item) {
+	t.Helper()
+
+	pageSize := os.Getpagesize()
+	data := make([]byte, 5*pageSize)
+	page := func(number int) []byte {
+		return data[number*pageSize : (number+1)*pageSize]
+	}
+
+	binary.LittleEndian.PutUint32(page(0), magicNumber)
+	binary.LittleEndian.PutUint64(page(0)[magicNumberSize+pageNumberSize:], 1)
+
+	binary.LittleEndian.PutUint64(page(1), 4)
+	binary.LittleEndian.PutUint64(page(1)[pageNumberSize:], 1)
+	binary.LittleEndian.PutUint64(page(1)[2*pageNumberSize:], 4)
+
+	if items != nil {
+		binary.LittleEndian.PutUint64(page(0)[magicNumberSize:], 2)
+
+		legacyCollection := make([]byte, legacyCollectionSize)
+		binary.LittleEndian.PutUint64(legacyCollection, 3)
+
+		serializeLegacyLeaf(page(2), []*Item{NewItem(name, legacyCollection)})
+		serializeLegacyLeaf(page(3), items)
+	}
+
+	if err := os.WriteFile(path, data, fileMode); err != nil {
+		t.Fatalf("failed to write headerless file: %v", err)
+	}
+}
+
+func TestUpgradeHeaderless(t *testing.T) {
+	t.Parallel()
+
+	expected := map[string][]byte{}
+	items := make([]*Item, 0)
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", i)
+		expected[key] = bytes.Repeat([]byte{byte(i)}, i*40)
+		items = append(items, NewItem([]byte(key), expected[key]))
+	}
+
+	tests := []struct {
+		name        string
+		items       []*Item
+		interrupted bool
+	}{
+		{name: "empty"},
+		{name: "collection", items: items},
+		{name: "interrupted", items: items, interrupted: true},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "db")
+			writeHeaderlessFile(t, path, []byte("collection"), test.items)
+
+			if test.interrupted {
+				interruptHeaderlessUpgrade(t, path)
+			}
+
+			if _, err := Open(path, nil); !errors.Is(err, ErrUnsupportedVersion) {
+				t.Fatalf("expected ErrUnsupportedVersion before the upgrade, got %v", err)
+			}
+
+			if err := Upgrade(path); err != nil {
+				t.Fatalf("failed to upgrade: %v", err)
+			}
+
+			checkUpgradedHeaderless(t, path, test.items != nil, expected)
+		})
+	}
+}
+
+// interruptHeaderlessUpgrade writes a partial meta to the freelist page of a headerless file, like an upgrade that was
+// interrupted while writing the upgraded meta.
+func interruptHeaderlessUpgrade(t *testing.T, path string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_RDWR, fileMode)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+
+	upgraded := &dal{pageSize: uint(os.Getpagesize())}
+	metaPage := upgraded.newMetaPage(meta{txid: 1, rootPageNumber: 5, freelistPageNumber: 6, version: formatVersion})
+
+	// the checksum in the page header is missing, so the meta page is corrupt
+	offset := int64(metaPage.number) * int64(len(metaPage.data))
+
+	if _, err = file.WriteAt(metaPage.data[:pageHeaderSize+metaSize], offset); err != nil {
+		t.Fatalf("failed to write partial meta: %v", err)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatalf("failed to close file: %v", err)
+	}
+}
+
+// checkUpgradedHeaderless opens an upgraded headerless file and checks its content. If the file had no collection,
+// one is created to check that the root collection can be written.
+func checkUpgradedHeaderless(t *testing.T, path string, hasCollection bool, expected map[string][]byte) {
+	t.Helper()
+
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("failed to open upgraded database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("failed to close database: %v", err)
+		}
+	})
+
+	if !hasCollection {
+		expected = putKeys(t, db, 100, 10)
+	}
+
+	checkCommitted(t, db, expected)
+	checkLen(t, db, len(expected))
+
+	for _, released := range db.freelist.releasedPages {
+		if released < metaPageNumber+metaPageCount {
+			t.Fatalf("meta page %d was released", released)
+		}
+	}
+}
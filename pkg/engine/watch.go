@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+const (
+	// changeHistorySize defines the number of commits whose changes are retained to resume watches.
+	changeHistorySize = 1024
+	// defaultWatchBufferSize defines the number of events buffered for a watch by default.
+	defaultWatchBufferSize = 64
+)
+
+// SlowConsumerPolicy defines how a watch handles a consumer that does not keep up with the commits.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerDrop ends the watch with ErrSlowConsumer once its buffer is full.
+	SlowConsumerDrop SlowConsumerPolicy = iota
+	// SlowConsumerBlock waits until the consumer receives the events, which blocks further commits meanwhile.
+	SlowConsumerBlock
+)
+
+var (
+	ErrSlowConsumer      = errors.New("watch consumer does not keep up with the commits")
+	ErrResumeUnavailable = errors.New("changes to resume the watch from are not retained anymore")
+)
+
+// WatchOptions defines the settings of a watch.
+type WatchOptions struct {
+	// BufferSize defines the number of events buffered for the consumer. Zero selects the default.
+	BufferSize int
+	// Policy defines how a consumer that does not keep up is handled.
+	Policy SlowConsumerPolicy
+	// ResumeAfter defines the transaction id of the last commit the consumer has seen. The changes of later commits
+	// are delivered first, as long as they are retained. They are replayed in the background, which waits for the
+	// consumer regardless of the Policy. Zero starts with the next commit.
+	ResumeAfter uint64
+}
+
+// WatchEvent is a change delivered by a watch together with the transaction id of its commit. The last event of a
+// watch that was ended by the database holds the error instead of a change. The error is left out if the channel is
+// full, which can only happen after retained changes were replayed.
+type WatchEvent struct {
+	Err error
+	Change
+	Txid uint64
+}
+
+// committedChanges holds the changes of a commit.
+type committedChanges struct {
+	changes []Change
+	txid    uint64
+}
+
+// watcher delivers the changes of a collection with a key prefix to the channel of a watch.
+type watcher struct {
+	ctx         context.Context //nolint:containedctx
+	events      chan WatchEvent
+	done        chan struct{}
+	unsubscribe func()
+	collection  []byte
+	prefix      []byte
+	options     WatchOptions
+	lock        sync.Mutex
+	closed      bool
+	// queueLock guards the events of commits that are queued while the retained changes are replayed. It is never
+	// held while events are sent, so commits don't wait for the replay.
+	queueLock  sync.Mutex
+	queue      []WatchEvent
+	replaying  bool
+	overflowed bool
+}
+
+// Watch returns a channel of the committed changes of keys with given prefix in given collection. The channel is
+// closed once the context is done or the consumer does not keep up according to the SlowConsumerPolicy. If options is
+// nil, the default options are used. Changes of sub-collections are not watched.
+func (db *DB) Watch(
+	ctx context.Context, collection []byte, prefix []byte, options *WatchOptions,
+) (<-chan WatchEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		options = &WatchOptions{}
+	}
+
+	w := newWatcher(ctx, collection, prefix, *options)
+
+	// the watcher is locked until the retained changes are replayed, so later commits are delivered after them
+	w.lock.Lock()
+	w.replaying = true
+
+	history, err := db.subscribeWatcher(w)
+	if err != nil {
+		w.lock.Unlock()
+
+		return nil, err
+	}
+
+	go w.closeOnDone()
+	go w.replay(history)
+
+	return w.events, nil
+}
+
+// newWatcher creates a watcher of given collection and prefix, which is not subscribed yet.
+func newWatcher(ctx context.Context, collection []byte, prefix []byte, options WatchOptions) *watcher {
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultWatchBufferSize
+	}
+
+	return &watcher{
+		ctx: ctx,
+		// one more slot than buffered events is reserved for the error that ends the watch
+		events:     make(chan WatchEvent, options.BufferSize+1),
+		done:       make(chan struct{}),
+		collection: collection,
+		prefix:     prefix,
+		options:    options,
+	}
+}
+
+// subscribeWatcher registers given watcher for the change feed and returns the retained changes it has to resume
+// from.
+func (db *DB) subscribeWatcher(w *watcher) ([]committedChanges, error) {
+	db.feedLock.Lock()
+	defer db.feedLock.Unlock()
+
+	history := make([]committedChanges, 0)
+
+	if w.options.ResumeAfter != 0 {
+		if w.options.ResumeAfter < db.historyStart {
+			return nil, ErrResumeUnavailable
+		}
+
+		for _, committed := range db.history {
+			if committed.txid > w.options.ResumeAfter {
+				history = append(history, committed)
+			}
+		}
+	}
+
+	db.lastSubscriber++
+	id := db.lastSubscriber
+	db.subscribers[id] = w.send
+
+	w.unsubscribe = func() {
+		db.feedLock.Lock()
+		defer db.feedLock.Unlock()
+
+		delete(db.subscribers, id)
+	}
+
+	return history, nil
+}
+
+// retain keeps the changes of the commit with given transaction id to resume watches from.
+func (db *DB) retain(txid uint64, changes []Change) {
+	db.history = append(db.history, committedChanges{txid: txid, changes: changes})
+
+	if len(db.history) > changeHistorySize {
+		db.historyStart = db.history[0].txid
+		db.history = db.history[1:]
+	}
+}
+
+// send delivers the matching changes of a commit, it is the ChangeHandler of the watcher. While the retained changes
+// are replayed, the events are queued up to the buffer size. Beyond that, the commit waits for the replay with
+// SlowConsumerBlock and the watch ends with ErrSlowConsumer after the replay with SlowConsumerDrop.
+func (w *watcher) send(txid uint64, changes []Change) {
+	events := w.matching(txid, changes)
+	if len(events) == 0 {
+		return
+	}
+
+	w.queueLock.Lock()
+	queued := w.replaying && w.enqueue(events)
+	w.queueLock.Unlock()
+
+	if queued {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.deliver(events, w.options.Policy)
+}
+
+// enqueue queues given events while the retained changes are replayed. It returns false if the queue is full and the
+// commit has to wait for the replay. The queue lock has to be held.
+func (w *watcher) enqueue(events []WatchEvent) bool {
+	switch {
+	case w.overflowed:
+		// the watch ends after the replay, so the events are dropped
+	case len(w.queue)+len(events) <= w.options.BufferSize:
+		w.queue = append(w.queue, events...)
+	case w.options.Policy == SlowConsumerDrop:
+		w.overflowed = true
+	default:
+		return false
+	}
+
+	return true
+}
+
+// replay delivers the retained changes and the events queued meanwhile. It waits for the consumer regardless of the
+// SlowConsumerPolicy, since commits don't wait for the replay. The lock of the watcher is held by the caller and
+// released once the replay is done.
+func (w *watcher) replay(history []committedChanges) {
+	defer w.lock.Unlock()
+
+	for _, committed := range history {
+		if !w.deliver(w.matching(committed.txid, committed.changes), SlowConsumerBlock) {
+			break
+		}
+	}
+
+	for {
+		w.queueLock.Lock()
+
+		queue, overflowed := w.queue, w.overflowed
+		w.queue = nil
+
+		if w.closed || (len(queue) == 0 && !overflowed) {
+			w.replaying = false
+			w.queueLock.Unlock()
+
+			return
+		}
+
+		w.queueLock.Unlock()
+
+		if w.deliver(queue, SlowConsumerBlock) && overflowed {
+			w.close(ErrSlowConsumer)
+		}
+	}
+}
+
+// matching returns the events of the changes of a commit that match the collection and the prefix of the watcher.
+func (w *watcher) matching(txid uint64, changes []Change) []WatchEvent {
+	events := make([]WatchEvent, 0)
+
+	for _, change := range changes {
+		if len(change.Parents) != 0 || !bytes.Equal(change.Collection, w.collection) ||
+			!bytes.HasPrefix(change.Key, w.prefix) {
+			continue
+		}
+
+		events = append(events, WatchEvent{Change: change, Txid: txid})
+	}
+
+	return events
+}
+
+// deliver passes given events to the channel according to given SlowConsumerPolicy. It returns false if the watch is
+// closed. The lock of the watcher has to be held.
+func (w *watcher) deliver(events []WatchEvent, policy SlowConsumerPolicy) bool {
+	for _, event := range events {
+		if w.closed {
+			return false
+		}
+
+		if policy == SlowConsumerBlock {
+			select {
+			case w.events <- event:
+			case <-w.ctx.Done():
+				w.close(nil)
+			}
+
+			continue
+		}
+
+		if len(w.events) >= w.options.BufferSize {
+			w.close(ErrSlowConsumer)
+
+			continue
+		}
+
+		w.events <- event
+	}
+
+	return !w.closed
+}
+
+// closeOnDone closes the watch once its context is done.
+func (w *watcher) closeOnDone() {
+	select {
+	case <-w.ctx.Done():
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		w.close(nil)
+	case <-w.done:
+	}
+}
+
+// close unsubscribes the watcher and closes the channel after passing given error, if any. The error is only passed if
+// the channel has room, since the lock is held by a commit or the replay, which must not wait for the consumer. The
+// slot reserved for the error is taken if the replay filled the channel. The lock of the watcher has to be held.
+func (w *watcher) close(err error) {
+	if w.closed {
+		return
+	}
+
+	w.closed = true
+	w.unsubscribe()
+
+	if err != nil {
+		select {
+		case w.events <- WatchEvent{Err: err}:
+		default:
+		}
+	}
+
+	close(w.events)
+	close(w.done)
+}
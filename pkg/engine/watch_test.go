@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// putValue commits given key into the collection of the watch tests.
+func putValue(t *testing.T, db *DB, key string) {
+	t.Helper()
+
+	err := db.Update(func(tx *Transaction) error {
+		collection, err := tx.GetCollection([]byte("collection"))
+		if err != nil {
+			return err
+		}
+
+		return collection.Put([]byte(key), []byte("value"))
+	})
+	if err != nil {
+		t.Fatalf("failed to put %q: %v", key, err)
+	}
+}
+
+// receive returns the next event of given watch, it fails the test if no event arrives in time.
+func receive(t *testing.T, events <-chan WatchEvent) (WatchEvent, bool) {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		return event, ok
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event was delivered")
+	}
+
+	return WatchEvent{}, false
+}
+
+// watchRetained starts a watch that resumes from 10 retained commits with a buffer that can't hold them.
+func watchRetained(t *testing.T, db *DB, policy SlowConsumerPolicy) <-chan WatchEvent {
+	t.Helper()
+
+	// the collection is created by the first commit, which has no changes
+	err := db.Update(func(tx *Transaction) error {
+		_, err := tx.CreateCollection([]byte("collection"))
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create collection: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		putValue(t, db, fmt.Sprintf("key%02d", i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	watched := make(chan (<-chan WatchEvent))
+
+	go func() {
+		events, err := db.Watch(ctx, []byte("collection"), nil, &WatchOptions{
+			BufferSize: 2, Policy: policy, ResumeAfter: 1,
+		})
+		if err != nil {
+			t.Errorf("failed to watch: %v", err)
+		}
+
+		watched <- events
+	}()
+
+	select {
+	case events := <-watched:
+		return events
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch did not return while the retained changes were replayed")
+	}
+
+	return nil
+}
+
+// TestWatchResume resumes a watch from more retained changes than its buffer holds and commits while they are
+// replayed. Watch blocked with SlowConsumerBlock and ended the watch with SlowConsumerDrop.
+func TestWatchResume(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+	}{
+		{name: "block", policy: SlowConsumerBlock},
+		{name: "drop", policy: SlowConsumerDrop},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := openTestDB(t, 512)
+			events := watchRetained(t, db, test.policy)
+
+			// the commit is queued until the retained changes are replayed
+			putValue(t, db, "key10")
+
+			for i := 0; i <= 10; i++ {
+				event, ok := receive(t, events)
+				if !ok || event.Err != nil || string(event.Key) != fmt.Sprintf("key%02d", i) {
+					t.Fatalf("expected key%02d as event %d, got %q: %v", i, i, event.Key, event.Err)
+				}
+			}
+
+			putValue(t, db, "key11")
+
+			if event, ok := receive(t, events); !ok || string(event.Key) != "key11" {
+				t.Fatalf("expected key11 after the replay, got %q: %v", event.Key, event.Err)
+			}
+		})
+	}
+}
+
+// TestWatchResumeOverflow commits more changes than the buffer holds while the retained changes are replayed. The
+// commits must not wait for the consumer with SlowConsumerDrop, the watch ends after the queued events instead.
+func TestWatchResumeOverflow(t *testing.T) {
+	t.Parallel()
+
+	db := openTestDB(t, 512)
+	events := watchRetained(t, db, SlowConsumerDrop)
+
+	for i := 10; i < 15; i++ {
+		putValue(t, db, fmt.Sprintf("key%02d", i))
+	}
+
+	for i := 0; i < 12; i++ {
+		event, ok := receive(t, events)
+		if !ok || event.Err != nil || string(event.Key) != fmt.Sprintf("key%02d", i) {
+			t.Fatalf("expected key%02d as event %d, got %q: %v", i, i, event.Key, event.Err)
+		}
+	}
+
+	if event, ok := receive(t, events); !ok || !errors.Is(event.Err, ErrSlowConsumer) {
+		t.Fatalf("expected ErrSlowConsumer, got %q: %v", event.Key, event.Err)
+	}
+
+	if _, ok := receive(t, events); ok {
+		t.Fatal("the watch was not closed")
+	}
+}
+
+// TestWatchCloseFull commits a change to watches whose channel was filled by the replay and cancels them while the
+// consumer does not receive. The error that ended a SlowConsumerDrop watch was sent blocking with the lock held, so the
+// commit never returned and the watch was not closed on cancel either.
+func TestWatchCloseFull(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+	}{
+		{name: "block", policy: SlowConsumerBlock},
+		{name: "drop", policy: SlowConsumerDrop},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			w := newWatcher(ctx, []byte("collection"), nil, WatchOptions{BufferSize: 2, Policy: test.policy})
+			w.unsubscribe = func() {}
+
+			// the replay waits for the consumer, so it fills the slot reserved for the error as well
+			for len(w.events) < cap(w.events) {
+				w.events <- WatchEvent{Txid: 1}
+			}
+
+			go w.closeOnDone()
+
+			sent := make(chan struct{})
+
+			go func() {
+				w.send(2, []Change{{Collection: []byte("collection"), Key: []byte("key")}})
+				close(sent)
+			}()
+
+			// a commit to a SlowConsumerBlock watch waits until the watch is cancelled
+			cancel()
+
+			select {
+			case <-sent:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the commit was blocked by the full channel")
+			}
+
+			for i := 0; i < cap(w.events); i++ {
+				if event, ok := receive(t, w.events); !ok || event.Txid != 1 {
+					t.Fatalf("expected the replayed event %d, got %q: %v", i, event.Key, event.Err)
+				}
+			}
+
+			if _, ok := receive(t, w.events); ok {
+				t.Fatal("the watch was not closed")
+			}
+		})
+	}
+}